@@ -0,0 +1,65 @@
+package internal
+
+import "strconv"
+
+// Batch types.
+const (
+	BatchLabeledResponse = "labeled-response"
+	BatchMultiline       = "draft/multiline"
+)
+
+type taggedReply struct {
+	reply string
+	tags  Tags
+}
+
+// labeledResponse collects everything sent to a client while it has a
+// labeled command handled, so the response can be sent as one unit.
+type labeledResponse struct {
+	label   string
+	replies []taggedReply
+}
+
+// nextBatchID returns a batch reference that is unique on the connection.
+func (c *Client) nextBatchID() string {
+	c.batchID += 1
+	return strconv.FormatUint(c.batchID, 36)
+}
+
+// sendLabeledResponse sends the collected response: a bare ACK if there
+// was none, the single reply labeled, or else a labeled batch of them.
+func (c *Client) sendLabeledResponse() {
+	response := c.response
+	if response == nil {
+		// the client quit while the command was handled
+		return
+	}
+	c.response = nil
+
+	switch {
+	case len(response.replies) == 0:
+		c.ReplyWithTags(RplAck(c.server), Tags{"label": response.label})
+
+	case len(response.replies) == 1 || !c.capabilities[Batch]:
+		// without batch the client can only match the first line
+		first := response.replies[0]
+		c.ReplyWithTags(first.reply, first.tags.with("label", response.label))
+		for _, reply := range response.replies[1:] {
+			c.ReplyWithTags(reply.reply, reply.tags)
+		}
+
+	default:
+		id := c.nextBatchID()
+		c.ReplyWithTags(RplBatchStart(c.server, id, BatchLabeledResponse),
+			Tags{"label": response.label})
+		for _, reply := range response.replies {
+			// lines of a batch nested in the response stay in it
+			if _, nested := reply.tags["batch"]; nested {
+				c.ReplyWithTags(reply.reply, reply.tags)
+				continue
+			}
+			c.ReplyWithTags(reply.reply, reply.tags.with("batch", id))
+		}
+		c.ReplyWithTags(RplBatchEnd(c.server, id), nil)
+	}
+}
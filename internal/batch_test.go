@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabeledResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "labeled-response", "batch")
+	bob := server.Register("bob")
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+
+	// several replies are wrapped in a batch
+	alice.Send("@label=who WHO #test")
+	start := alice.Expect(`^@label=who :irc.test.net BATCH \+(\S+) labeled-response$`)
+	id := start[1]
+	alice.Expect(`^@batch=%s :irc.test.net 352 alice #test bob `, id)
+	alice.Expect(`^@batch=%s :irc.test.net 315 alice #test `, id)
+	alice.Expect(`^:irc.test.net BATCH -%s$`, id)
+
+	// a single reply is labeled
+	alice.Send("@label=ping PING x")
+	alice.Expect(`^@label=ping :\S+ PONG \S+ :x$`)
+
+	// no reply at all is acknowledged
+	alice.Send("@label=pong PONG x")
+	alice.Expect(`^@label=pong :irc.test.net ACK$`)
+
+	// batch references are not reused
+	alice.Send("@label=names NAMES #test")
+	next := alice.Expect(`^@label=names :irc.test.net BATCH \+(\S+) labeled-response$`)
+	assert.NotEqual(id, next[1])
+	alice.Expect(`^:irc.test.net BATCH -%s$`, next[1])
+
+	// only the sender's own replies are part of the response
+	alice.Send("@label=join JOIN #test")
+	alice.Expect(`^@batch=\S+ :alice!\S+ JOIN #test$`)
+	bob.Expect(`^:alice!\S+ JOIN #test$`)
+
+	// clients without labeled-response don't get labels
+	bob.Send("@label=x PING x")
+	bob.Expect(`^:\S+ PONG \S+ :x$`)
+}
@@ -1,6 +1,13 @@
 package internal
 
-import "strings"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
 
 type CapSubCommand string
 
@@ -12,23 +19,66 @@ const (
 	CAP_NAK   CapSubCommand = "NAK"
 	CAP_CLEAR CapSubCommand = "CLEAR"
 	CAP_END   CapSubCommand = "END"
+	CAP_NEW   CapSubCommand = "NEW"
+	CAP_DEL   CapSubCommand = "DEL"
 )
 
+// CapVersion302 is the first CAP version to get capability values,
+// multiline replies and cap-notify.
+const CapVersion302 = 302
+
 // Capabilities are optional features a client may request from a server.
 type Capability string
 
 const (
-	MultiPrefix Capability = "multi-prefix"
-	SASL        Capability = "sasl"
+	AccountNotify   Capability = "account-notify"
+	AccountTag      Capability = "account-tag"
+	AwayNotify      Capability = "away-notify"
+	Batch           Capability = "batch"
+	CapNotify       Capability = "cap-notify"
+	ChgHost         Capability = "chghost"
+	EchoMessage     Capability = "echo-message"
+	ExtendedJoin    Capability = "extended-join"
+	InviteNotify    Capability = "invite-notify"
+	LabeledResponse Capability = "labeled-response"
+	MessageTags     Capability = "message-tags"
+	MultiPrefix     Capability = "multi-prefix"
+	Multiline       Capability = "draft/multiline"
+	SASL            Capability = "sasl"
+	ServerTime      Capability = "server-time"
+	SetName         Capability = "setname"
+	StandardReplies Capability = "standard-replies"
+	UserhostInNames Capability = "userhost-in-names"
 )
 
 var (
 	SupportedCapabilities = CapabilitySet{
-		MultiPrefix: true,
-		SASL:        true,
+		AccountNotify:   true,
+		AccountTag:      true,
+		AwayNotify:      true,
+		Batch:           true,
+		CapNotify:       true,
+		ChgHost:         true,
+		EchoMessage:     true,
+		ExtendedJoin:    true,
+		InviteNotify:    true,
+		LabeledResponse: true,
+		MessageTags:     true,
+		MultiPrefix:     true,
+		Multiline:       true,
+		SASL:            true,
+		ServerTime:      true,
+		SetName:         true,
+		StandardReplies: true,
+		UserhostInNames: true,
 	}
 )
 
+// CapabilityValues are advertised to CAP 302 clients as `name=value`.
+var CapabilityValues = map[Capability]string{
+	SASL: "PLAIN",
+}
+
 func (capability Capability) String() string {
 	return string(capability)
 }
@@ -55,50 +105,163 @@ const (
 	CapNegotiated  CapState = iota
 )
 
+// CapabilitySet is a set of capabilities. In a REQ a false value means the
+// capability is being disabled.
 type CapabilitySet map[Capability]bool
 
-func (set CapabilitySet) String() string {
-	strs := make([]string, len(set))
-	index := 0
+// Sorted returns the capabilities in the set in a stable order.
+func (set CapabilitySet) Sorted() []Capability {
+	capabilities := make([]Capability, 0, len(set))
 	for capability := range set {
-		strs[index] = string(capability)
-		index += 1
+		capabilities = append(capabilities, capability)
+	}
+	sort.Slice(capabilities, func(i, j int) bool {
+		return capabilities[i] < capabilities[j]
+	})
+	return capabilities
+}
+
+func (set CapabilitySet) String() string {
+	strs := make([]string, 0, len(set))
+	for _, capability := range set.Sorted() {
+		if !set[capability] {
+			strs = append(strs, Disable.String()+capability.String())
+		} else {
+			strs = append(strs, capability.String())
+		}
 	}
 	return strings.Join(strs, " ")
 }
 
 func (set CapabilitySet) DisableString() string {
-	parts := make([]string, len(set))
-	index := 0
-	for capability := range set {
-		parts[index] = Disable.String() + capability.String()
-		index += 1
+	parts := make([]string, 0, len(set))
+	for _, capability := range set.Sorted() {
+		parts = append(parts, Disable.String()+capability.String())
 	}
 	return strings.Join(parts, " ")
 }
 
+// Difference returns the capabilities in set that are not in other.
+func (set CapabilitySet) Difference(other CapabilitySet) CapabilitySet {
+	diff := make(CapabilitySet)
+	for capability := range set {
+		if !other[capability] {
+			diff[capability] = true
+		}
+	}
+	return diff
+}
+
+// NewCapabilitySet returns the supported capabilities, less those named in
+// disabled.
+func NewCapabilitySet(disabled []string) CapabilitySet {
+	set := make(CapabilitySet)
+	for capability := range SupportedCapabilities {
+		set[capability] = true
+	}
+	for _, name := range disabled {
+		capability := Capability(name)
+		if !set[capability] {
+			log.Warnf("can't disable unknown capability %s", name)
+			continue
+		}
+		delete(set, capability)
+	}
+	return set
+}
+
+// capabilityValue returns the value advertised for capability, if any.
+func (server *Server) capabilityValue(capability Capability) string {
+	if capability == Multiline {
+		return fmt.Sprintf("max-bytes=%d,max-lines=%d",
+			server.config.MultilineMaxBytes(), server.config.MultilineMaxLines())
+	}
+	return CapabilityValues[capability]
+}
+
+// capNames returns the names of capabilities as sent to client, with their
+// values for CAP 302 clients.
+func (client *Client) capNames(capabilities CapabilitySet) []string {
+	names := make([]string, 0, len(capabilities))
+	for _, capability := range capabilities.Sorted() {
+		name := capability.String()
+		if value := client.server.capabilityValue(capability); value != "" && client.capVersion >= CapVersion302 {
+			name += "=" + value
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// RplCapList sends names as one CAP reply, or, to CAP 302 clients, as many
+// as it takes to fit, with all but the last marked with a `*`.
+func (client *Client) RplCapList(subCommand CapSubCommand, names []string) {
+	if client.capVersion < CapVersion302 {
+		client.Reply(RplCap(client, subCommand, strings.Join(names, " ")))
+		return
+	}
+
+	baseLen := len(RplCapMore(client, subCommand, ""))
+	from, length := 0, baseLen
+	for index, name := range names {
+		if index > from && length+len(name) > MAX_REPLY_LEN {
+			client.Reply(RplCapMore(client, subCommand,
+				strings.Join(names[from:index], " ")))
+			from, length = index, baseLen
+		}
+		length += len(name) + 1
+	}
+	client.Reply(RplCap(client, subCommand, strings.Join(names[from:], " ")))
+}
+
 func (msg *CapCommand) HandleRegServer(server *Server) {
+	msg.handle(server)
+}
+
+func (msg *CapCommand) HandleServer(server *Server) {
+	msg.handle(server)
+}
+
+func (msg *CapCommand) handle(server *Server) {
 	client := msg.Client()
 
 	switch msg.subCommand {
 	case CAP_LS:
-		client.capState = CapNegotiating
-		client.Reply(RplCap(client, CAP_LS, SupportedCapabilities))
+		if !client.registered {
+			client.capState = CapNegotiating
+		}
+		if msg.version > client.capVersion {
+			client.capVersion = msg.version
+		}
+		if client.capVersion >= CapVersion302 && server.capabilities[CapNotify] {
+			client.capabilities[CapNotify] = true
+		}
+		client.RplCapList(CAP_LS, client.capNames(server.capabilities))
 
 	case CAP_LIST:
-		client.Reply(RplCap(client, CAP_LIST, client.capabilities))
+		client.RplCapList(CAP_LIST, client.capNames(client.capabilities))
 
 	case CAP_REQ:
-		for capability := range msg.capabilities {
-			if !SupportedCapabilities[capability] {
-				client.Reply(RplCap(client, CAP_NAK, msg.capabilities))
+		if !client.registered {
+			client.capState = CapNegotiating
+		}
+
+		// a request is accepted or rejected as a whole
+		for capability, enable := range msg.capabilities {
+			if !server.capabilities[capability] ||
+				(!enable && capability == CapNotify && client.capVersion >= CapVersion302) {
+				client.Reply(RplCap(client, CAP_NAK, msg.arg))
 				return
 			}
 		}
-		for capability := range msg.capabilities {
-			client.capabilities[capability] = true
+		for capability, enable := range msg.capabilities {
+			if enable {
+				client.capabilities[capability] = true
+			} else {
+				delete(client.capabilities, capability)
+			}
 		}
-		client.Reply(RplCap(client, CAP_ACK, msg.capabilities))
+		client.Reply(RplCap(client, CAP_ACK, msg.arg))
 
 	case CAP_CLEAR:
 		reply := RplCap(client, CAP_ACK, client.capabilities.DisableString())
@@ -106,6 +269,9 @@ func (msg *CapCommand) HandleRegServer(server *Server) {
 		client.Reply(reply)
 
 	case CAP_END:
+		if client.registered {
+			return
+		}
 		client.capState = CapNegotiated
 		server.tryRegister(client)
 
@@ -113,3 +279,42 @@ func (msg *CapCommand) HandleRegServer(server *Server) {
 		client.ErrInvalidCapCmd(msg.subCommand)
 	}
 }
+
+// updateCapabilities switches to offering capabilities, telling clients
+// with cap-notify what was added and removed. Removed capabilities are
+// disabled for everyone.
+func (server *Server) updateCapabilities(capabilities CapabilitySet) {
+	added := capabilities.Difference(server.capabilities)
+	removed := server.capabilities.Difference(capabilities)
+	server.capabilities = capabilities
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	server.clients.Range(func(_ Name, client *Client) bool {
+		notify := client.capabilities[CapNotify]
+		for capability := range removed {
+			delete(client.capabilities, capability)
+		}
+		if !notify {
+			return true
+		}
+		if len(removed) > 0 {
+			client.RplCapList(CAP_DEL, client.capNames(removed))
+		}
+		if len(added) > 0 {
+			client.RplCapList(CAP_NEW, client.capNames(added))
+		}
+		return true
+	})
+}
+
+// parseCapVersion parses the version argument of CAP LS; anything that
+// isn't a number is treated as no version.
+func parseCapVersion(arg string) int {
+	version, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0
+	}
+	return version
+}
@@ -0,0 +1,348 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	assert := assert.New(t)
+
+	plain := &Client{capabilities: make(CapabilitySet)}
+	tagged := &Client{capabilities: CapabilitySet{AccountTag: true}}
+
+	tags := Tags{"account": "alice", "time": "2020-01-01T00:00:00.000Z"}
+	assert.Equal("", tags.For(plain))
+	assert.Equal("@account=alice ", tags.For(tagged))
+
+	tagged.capabilities[ServerTime] = true
+	assert.Equal("@account=alice;time=2020-01-01T00:00:00.000Z ", tags.For(tagged))
+	assert.Regexp(`^@time=\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z $`, Tags(nil).For(tagged))
+
+	assert.Equal(`a\:b\sc\\d\r\n`, EscapeTagValue("a;b c\\d\r\n"))
+}
+
+func TestParseTags(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(Tags{"a": "b c", "d": "", "e": "f;g\\"}, ParseTags(`a=b\sc;d;e=f\:g\\`))
+	assert.Equal(Tags{"a": "xy"}, ParseTags(`a=x\y\;;a=xy`))
+	assert.Equal("a;b c\\d\r\n", UnescapeTagValue(EscapeTagValue("a;b c\\d\r\n")))
+	assert.Equal("ab", UnescapeTagValue(`\a\b\`))
+
+	cmd, err := ParseCommand(`@label=abc;+draft/x=1 PING :x`)
+	assert.NoError(err)
+	assert.Equal(PING, cmd.Code())
+	assert.Equal("abc", cmd.Label())
+	assert.Equal(Tags{"label": "abc", "+draft/x": "1"}, cmd.Tags())
+}
+
+func TestServerTime(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "server-time")
+
+	alice.Send("PING x")
+	alice.Expect(`^@time=\S+Z :\S+ PONG \S+ :x$`)
+}
+
+func TestEchoMessage(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "echo-message")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("PRIVMSG #test :hi all")
+	alice.Expect(`^:alice!\S+ PRIVMSG #test :hi all$`)
+	alice.Send("NOTICE bob :hi bob")
+	alice.Expect(`^:alice!\S+ NOTICE bob :hi bob$`)
+	bob.Expect(`^:alice!\S+ NOTICE bob :hi bob$`)
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+	bob.Send("PRIVMSG #test :no echo")
+	alice.Expect(`^:bob!\S+ PRIVMSG #test :no echo$`)
+	bob.ExpectNone(`PRIVMSG #test :no echo`)
+}
+
+func TestAwayNotify(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "away-notify")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+
+	bob.Send("AWAY :lunch")
+	bob.Expect(`^:irc.test.net 306 bob `)
+
+	// joining while away tells the channel
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	alice.Expect(`^:bob!\S+ AWAY :lunch$`)
+
+	bob.Send("AWAY")
+	bob.Expect(`^:irc.test.net 305 bob `)
+	alice.Expect(`^:bob!\S+ AWAY$`)
+
+	alice.Send("AWAY :gone")
+	bob.ExpectNone(`AWAY`)
+}
+
+func TestExtendedJoin(t *testing.T) {
+	server := newSaslTestServer(t)
+	alice := server.RegisterWithCaps("alice", "extended-join")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test \* :alice$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test \* :bob$`)
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+}
+
+func TestAccountTag(t *testing.T) {
+	server := newSaslTestServer(t)
+	bob := server.RegisterWithCaps("bob", "account-tag", "extended-join")
+
+	alice := server.Connect()
+	alice.Send("CAP LS")
+	alice.Send("CAP REQ :sasl")
+	alice.Send("AUTHENTICATE PLAIN")
+	alice.Send("AUTHENTICATE %s", saslPlain("alice", "secret"))
+	alice.Expect(` 903 `)
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Send("CAP END")
+	alice.Expect(` 001 alice `)
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test \* :bob$`)
+	alice.Send("JOIN #test")
+	bob.Expect(`^@account=alice :alice!\S+ JOIN #test alice :Alice$`)
+	alice.Send("PRIVMSG #test :hello")
+	bob.Expect(`^@account=alice :alice!\S+ PRIVMSG #test :hello$`)
+}
+
+func TestInviteNotify(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.RegisterWithCaps("bob", "invite-notify")
+	carol := server.Register("carol")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	alice.Send("MODE #test +o bob")
+	bob.Expect(`MODE #test \+o bob$`)
+
+	alice.Send("INVITE carol #test")
+	carol.Expect(`^:alice!\S+ INVITE carol :#test$`)
+	bob.Expect(`^:alice!\S+ INVITE carol :#test$`)
+}
+
+func TestUserhostInNames(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "userhost-in-names", "multi-prefix")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:irc.test.net 353 alice = #test :@alice!alice@\S+$`)
+}
+
+func TestCapLS302(t *testing.T) {
+	server := newTestServer(t)
+
+	old := server.Connect()
+	old.Send("CAP LS")
+	old.Expect(`^:irc.test.net CAP \* LS :[^=]*\bsasl\b[^=]*$`)
+
+	alice := server.Connect()
+	alice.Send("CAP LS 302")
+	alice.Expect(`^:irc.test.net CAP \* LS :.*\bsasl=PLAIN\b`)
+
+	// cap-notify comes with 302
+	alice.Send("CAP LIST")
+	alice.Expect(`^:irc.test.net CAP \* LIST :cap-notify$`)
+	alice.Send("CAP REQ :-cap-notify")
+	alice.Expect(`^:irc.test.net CAP \* NAK :-cap-notify$`)
+
+	// negotiation holds registration until CAP END
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Send("CAP LIST")
+	alice.Expect(`( 001 alice |CAP alice LIST :cap-notify$)`)
+	alice.Send("CAP END")
+	alice.Expect(` 001 alice `)
+}
+
+func TestCapMultilineLS(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t)
+	client := &Client{
+		server:       server.Server,
+		capabilities: make(CapabilitySet),
+		capVersion:   CapVersion302,
+		hasQuit:      NewSyncBool(false),
+		sendQ:        NewSendQueue(0),
+	}
+
+	names := make([]string, 100)
+	for index := range names {
+		names[index] = fmt.Sprintf("vendor.example/capability-%02d", index)
+	}
+	client.RplCapList(CAP_LS, names)
+	client.sendQ.Close()
+
+	var lines []string
+	for {
+		line, ok := client.sendQ.Pop()
+		if !ok {
+			break
+		}
+		assert.True(len(line) <= MAX_REPLY_LEN, line)
+		lines = append(lines, line)
+	}
+
+	if assert.True(len(lines) > 1) {
+		var got []string
+		for index, line := range lines {
+			if index < len(lines)-1 {
+				assert.Regexp(`^:irc.test.net CAP \* LS \* :`, line)
+			} else {
+				assert.Regexp(`^:irc.test.net CAP \* LS :`, line)
+			}
+			got = append(got, strings.Fields(line[strings.LastIndex(line, ":")+1:])...)
+		}
+		assert.Equal(names, got)
+	}
+}
+
+func TestCapReq(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+
+	// after registration too
+	alice.Send("CAP REQ :multi-prefix server-time")
+	alice.Expect(`^(@\S+ )?:irc.test.net CAP alice ACK :multi-prefix server-time$`)
+	alice.Send("CAP LIST")
+	alice.Expect(`^@time=\S+ :irc.test.net CAP alice LIST :multi-prefix server-time$`)
+
+	alice.Send("CAP REQ :-server-time")
+	alice.Expect(`CAP alice ACK :-server-time$`)
+	alice.Send("CAP LIST")
+	alice.Expect(`^(@\S+ )?:irc.test.net CAP alice LIST :multi-prefix$`)
+
+	// all or nothing
+	alice.Send("CAP REQ :echo-message bogus")
+	alice.Expect(`^:irc.test.net CAP alice NAK :echo-message bogus$`)
+	alice.Send("CAP LIST")
+	alice.Expect(`^:irc.test.net CAP alice LIST :multi-prefix$`)
+
+	alice.Send("CAP END")
+	alice.Send("CAP FOO")
+	alice.Expect(`^:irc.test.net 410 alice FOO `)
+}
+
+func TestCapNotify(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ircd.yml")
+	writeConfig := func(disabled string) {
+		err := os.WriteFile(filename, []byte(`
+network:
+  name: TestNet
+server:
+  name: irc.test.net
+  listen: ["127.0.0.1:0"]
+capabilities:
+  disabled: [`+disabled+`]
+`), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := newTestServer(t, withOper(t), func(config *Config) {
+		config.filename = filename
+		config.Capabilities.Disabled = []string{"echo-message"}
+	})
+	oper := server.Register("oper")
+	oper.Oper()
+
+	alice := server.Connect()
+	alice.Send("CAP LS 302")
+	alice.Expect(`CAP \* LS :`)
+	alice.Send("CAP REQ :server-time")
+	alice.Expect(`CAP \* ACK :server-time$`)
+	alice.Send("CAP REQ :echo-message")
+	alice.Expect(`CAP \* NAK :echo-message$`)
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Send("CAP END")
+	alice.Expect(` 001 alice `)
+
+	old := server.RegisterWithCaps("old", "server-time")
+
+	writeConfig("server-time")
+	oper.Send("REHASH")
+	oper.Expect(` 382 oper `)
+
+	alice.Expect(`^:irc.test.net CAP alice DEL :server-time$`)
+	alice.Expect(`^:irc.test.net CAP alice NEW :echo-message$`)
+	alice.Send("CAP LIST")
+	alice.Expect(`^:irc.test.net CAP alice LIST :cap-notify$`)
+	alice.Send("CAP REQ :echo-message")
+	alice.Expect(`CAP alice ACK :echo-message$`)
+
+	// without cap-notify the capability just goes away
+	old.ExpectNone(`CAP old (DEL|NEW)`)
+	old.Send("CAP LIST")
+	old.Expect(`^:irc.test.net CAP old LIST :$`)
+}
+
+func TestMessageTags(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "message-tags", "echo-message")
+	bob := server.RegisterWithCaps("bob", "message-tags")
+	carol := server.Register("carol")
+
+	for _, client := range []*testClient{alice, bob, carol} {
+		client.Send("JOIN #test")
+		client.Expect(`^(@\S+ )?:%s!\S+ JOIN #test$`, client.nick)
+	}
+
+	// messages get a msgid, and client-only tags are relayed
+	alice.Send("@+draft/reply=abc;foo=bar PRIVMSG #test :hi")
+	echo := alice.Expect(`^@\+draft/reply=abc;msgid=(\S+) :alice!\S+ PRIVMSG #test :hi$`)
+	relayed := bob.Expect(`^@\+draft/reply=abc;msgid=(\S+) :alice!\S+ PRIVMSG #test :hi$`)
+	assert.Equal(echo[1], relayed[1])
+	carol.Expect(`^:alice!\S+ PRIVMSG #test :hi$`)
+
+	alice.Send("PRIVMSG bob :hi again")
+	next := bob.Expect(`^@msgid=(\S+) :alice!\S+ PRIVMSG bob :hi again$`)
+	assert.NotEqual(relayed[1], next[1])
+
+	// TAGMSG only goes to clients with message-tags
+	alice.Send("@+draft/react=:) TAGMSG #test")
+	alice.Expect(`^@\+draft/react=:\);msgid=\S+ :alice!\S+ TAGMSG #test$`)
+	bob.Expect(`^@\+draft/react=:\);msgid=\S+ :alice!\S+ TAGMSG #test$`)
+	carol.ExpectNone(`TAGMSG`)
+
+	alice.Send("@+draft/react=:) TAGMSG carol")
+	alice.Expect(`TAGMSG carol$`)
+	carol.ExpectNone(`TAGMSG`)
+
+	// and is subject to the same rules as PRIVMSG
+	alice.Send("MODE #test +m")
+	bob.Expect(`MODE #test \+m`)
+	bob.Send("@+draft/react=:( TAGMSG #test")
+	bob.Expect(` 404 bob #test `)
+	alice.ExpectNone(`TAGMSG`)
+}
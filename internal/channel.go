@@ -1,16 +1,74 @@
 package internal
 
-import "strconv"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 type Channel struct {
-	flags     *ChannelModeSet
-	lists     map[ChannelMode]*UserMaskSet
-	key       Text
-	members   *MemberSet
-	name      Name
-	server    *Server
-	topic     Text
-	userLimit uint64
+	flags        *ChannelModeSet
+	lists        map[ChannelMode]*UserMaskSet
+	key          Text
+	members      *MemberSet
+	name         Name
+	server       *Server
+	topic        Text
+	userLimit    uint64
+	joinThrottle *JoinThrottleLimit
+	redirect     Name
+	knocks       *FloodControl
+}
+
+// JoinThrottleLimit is the +j setting: at most joins joins in every period.
+// Only successful joins count, and the window restarts with the first join
+// after it ran out.
+type JoinThrottleLimit struct {
+	joins  int
+	period time.Duration
+	start  time.Time
+	count  int
+}
+
+// ParseJoinThrottle parses the <joins>:<seconds> parameter of +j.
+func ParseJoinThrottle(arg string) (*JoinThrottleLimit, error) {
+	joins, seconds, found := strings.Cut(arg, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid join throttle: %s", arg)
+	}
+	n, err := strconv.Atoi(joins)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid join throttle: %s", arg)
+	}
+	t, err := strconv.Atoi(seconds)
+	if err != nil || t <= 0 {
+		return nil, fmt.Errorf("invalid join throttle: %s", arg)
+	}
+	return &JoinThrottleLimit{
+		joins:  n,
+		period: time.Duration(t) * time.Second,
+	}, nil
+}
+
+func (limit *JoinThrottleLimit) String() string {
+	return fmt.Sprintf("%d:%d", limit.joins, int(limit.period/time.Second))
+}
+
+// Full reports whether another join now would be over the limit.
+func (limit *JoinThrottleLimit) Full(now time.Time) bool {
+	return now.Sub(limit.start) < limit.period && limit.count >= limit.joins
+}
+
+// Joined counts a join made at now.
+func (limit *JoinThrottleLimit) Joined(now time.Time) {
+	if now.Sub(limit.start) >= limit.period {
+		limit.start = now
+		limit.count = 0
+	}
+	limit.count++
 }
 
 // NewChannel creates a new channel from a `Server` and a `name`
@@ -22,10 +80,12 @@ func NewChannel(s *Server, name Name, addDefaultModes bool) *Channel {
 			BanMask:    NewUserMaskSet(),
 			ExceptMask: NewUserMaskSet(),
 			InviteMask: NewUserMaskSet(),
+			QuietMask:  NewUserMaskSet(),
 		},
 		members: NewMemberSet(),
 		name:    name,
 		server:  s,
+		knocks:  NewFloodControl(s.config.KnockThrottle()),
 	}
 
 	if addDefaultModes {
@@ -49,13 +109,12 @@ func (channel *Channel) Names(client *Client) {
 }
 
 func (channel *Channel) ClientIsOperator(client *Client) bool {
-	return client.modes.Has(Operator) || channel.members.HasMode(client, ChannelOperator)
+	return client.HasPrivilege(PrivOverrideChannel) || channel.members.HasMode(client, ChannelOperator)
 }
 
 func (channel *Channel) Nicks(target *Client) []string {
 	isMultiPrefix := (target != nil) && target.capabilities[MultiPrefix]
-	channel.members.RLock()
-	defer channel.members.RUnlock()
+	isUserhostInNames := (target != nil) && target.capabilities[UserhostInNames]
 	nicks := make([]string, channel.members.Count())
 	i := 0
 	channel.members.Range(func(client *Client, modes *ChannelModeSet) bool {
@@ -73,7 +132,11 @@ func (channel *Channel) Nicks(target *Client) []string {
 				nicks[i] += "+"
 			}
 		}
-		nicks[i] += client.Nick().String()
+		if isUserhostInNames {
+			nicks[i] += client.UserHost(true).String()
+		} else {
+			nicks[i] += client.Nick().String()
+		}
 		i++
 		return true
 	})
@@ -94,9 +157,11 @@ func (channel *Channel) String() string {
 
 // <mode> <mode params>
 func (channel *Channel) ModeString(client *Client) (str string) {
-	isMember := client.modes.Has(Operator) || channel.members.Has(client)
+	isMember := client.HasPrivilege(PrivOverrideChannel) || channel.members.Has(client)
 	showKey := isMember && (channel.key != "")
 	showUserLimit := channel.userLimit > 0
+	showJoinThrottle := channel.joinThrottle != nil
+	showRedirect := channel.redirect != ""
 
 	// flags with args
 	if showKey {
@@ -105,6 +170,12 @@ func (channel *Channel) ModeString(client *Client) (str string) {
 	if showUserLimit {
 		str += UserLimit.String()
 	}
+	if showJoinThrottle {
+		str += JoinThrottle.String()
+	}
+	if showRedirect {
+		str += Redirect.String()
+	}
 
 	// flags
 	channel.flags.Range(func(mode ChannelMode) bool {
@@ -122,6 +193,12 @@ func (channel *Channel) ModeString(client *Client) (str string) {
 	if showUserLimit {
 		str += " " + strconv.FormatUint(channel.userLimit, 10)
 	}
+	if showJoinThrottle {
+		str += " " + channel.joinThrottle.String()
+	}
+	if showRedirect {
+		str += " " + channel.redirect.String()
+	}
 
 	return
 }
@@ -136,6 +213,12 @@ func (channel *Channel) CheckKey(key Text) bool {
 }
 
 func (channel *Channel) Join(client *Client, key Text) {
+	channel.join(client, key, true)
+}
+
+// join joins client to the channel, or to the +L channel when it is full
+// and redirect is set. A redirected join is never redirected again.
+func (channel *Channel) join(client *Client, key Text, redirect bool) {
 	if channel.members.Has(client) {
 		// already joined, no message?
 		return
@@ -144,6 +227,10 @@ func (channel *Channel) Join(client *Client, key Text) {
 	isOperator := channel.ClientIsOperator(client)
 
 	if !isOperator && channel.IsFull() {
+		if redirect && channel.redirect != "" {
+			channel.forward(client)
+			return
+		}
 		client.ErrChannelIsFull(channel)
 		return
 	}
@@ -153,20 +240,36 @@ func (channel *Channel) Join(client *Client, key Text) {
 		return
 	}
 
-	isInvited := channel.lists[InviteMask].Match(client.UserHost(false))
+	isInvited := client.IsInvited(channel) || channel.lists[InviteMask].Match(client)
 	if !isOperator && channel.flags.Has(InviteOnly) && !isInvited {
 		client.ErrInviteOnlyChan(channel)
 		return
 	}
 
-	if channel.lists[BanMask].Match(client.UserHost(false)) &&
+	if ban := channel.lists[BanMask].Find(client); ban != nil &&
 		!isInvited &&
 		!isOperator &&
-		!channel.lists[ExceptMask].Match(client.UserHost(false)) {
-		client.ErrBannedFromChan(channel)
+		!channel.lists[ExceptMask].Match(client) {
+		client.ErrBannedFromChan(channel, ban.reason)
+		return
+	}
+
+	if !isOperator && channel.flags.Has(RegisteredOnly) && client.Account() == "" {
+		client.ErrNeedReggedNick(channel)
 		return
 	}
 
+	now := time.Now()
+	if !isOperator && channel.joinThrottle != nil {
+		if channel.joinThrottle.Full(now) {
+			client.ErrJoinThrottled(channel)
+			return
+		}
+		channel.joinThrottle.Joined(now)
+	}
+
+	// an invite is good for one join
+	delete(client.invites, channel)
 	client.channels.Add(channel)
 	channel.members.Add(client)
 	if channel.members.Count() == 1 {
@@ -174,24 +277,58 @@ func (channel *Channel) Join(client *Client, key Text) {
 		channel.members.Get(client).Set(ChannelOperator)
 	}
 
+	span := channel.fanout(client, "JOIN")
+	defer span.End()
+
 	reply := RplJoin(client, channel)
+	extendedReply := RplExtendedJoin(client, channel)
+	tags := client.Tags()
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
-		member.Reply(reply)
+		if member.capabilities[ExtendedJoin] {
+			member.ReplyWithTags(extendedReply, tags)
+		} else {
+			member.ReplyWithTags(reply, tags)
+		}
 		return true
 	})
+
+	if client.modes.Has(Away) {
+		awayReply := RplAwayMsg(client, client.awayMessage)
+		channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+			if member != client && member.capabilities[AwayNotify] {
+				member.ReplyWithTags(awayReply, tags)
+			}
+			return true
+		})
+	}
+
 	channel.GetTopic(client)
 	channel.Names(client)
 }
 
+// forward sends client on to the +L channel, creating it if need be.
+func (channel *Channel) forward(client *Client) {
+	client.ErrLinkChannel(channel, channel.redirect)
+	target := channel.server.channels.Get(channel.redirect)
+	if target == nil {
+		target = NewChannel(channel.server, channel.redirect, true)
+	}
+	target.join(client, "", false)
+}
+
 func (channel *Channel) Part(client *Client, message Text) {
 	if !channel.members.Has(client) {
 		client.ErrNotOnChannel(channel)
 		return
 	}
 
+	span := channel.fanout(client, "PART")
+	defer span.End()
+
 	reply := RplPart(client, channel, message)
+	tags := client.Tags()
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
-		member.Reply(reply)
+		member.ReplyWithTags(reply, tags)
 		return true
 	})
 	channel.Quit(client)
@@ -245,23 +382,54 @@ func (channel *Channel) CanSpeak(client *Client) bool {
 	if channel.flags.Has(SecureChan) && !client.modes.Has(SecureConn) {
 		return false
 	}
+	if channel.members.HasMode(client, Voice) {
+		return true
+	}
+	if channel.flags.Has(RegisteredSpeak) && client.Account() == "" {
+		return false
+	}
+	if channel.lists[QuietMask].Match(client) &&
+		!channel.lists[ExceptMask].Match(client) {
+		return false
+	}
 	return true
 }
 
-func (channel *Channel) PrivMsg(client *Client, message Text) {
+// FilterMessage applies the channel's content modes to a message from
+// client. It returns the message to relay, with colors stripped under +S,
+// or the mode that rejects it.
+func (channel *Channel) FilterMessage(client *Client, message Text) (Text, ChannelMode) {
+	if channel.ClientIsOperator(client) {
+		return message, 0
+	}
+	if channel.flags.Has(NoCTCP) && IsCTCP(message) {
+		return message, NoCTCP
+	}
+	if HasColors(message) {
+		if channel.flags.Has(NoColors) {
+			return message, NoColors
+		}
+		if channel.flags.Has(StripColors) {
+			message = StripColorCodes(message)
+		}
+	}
+	return message, 0
+}
+
+func (channel *Channel) PrivMsg(client *Client, message Text, tags Tags) {
 	if !channel.CanSpeak(client) {
 		client.ErrCannotSendToChan(channel)
 		return
 	}
-	reply := RplPrivMsg(client, channel, message)
-	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
-		if member == client {
-			return true
-		}
-		client.server.metrics.Counter("client", "messages").Inc()
-		member.Reply(reply)
-		return true
-	})
+	message, mode := channel.FilterMessage(client, message)
+	if mode != 0 {
+		client.ErrCannotSendToChanMode(channel, mode)
+		return
+	}
+	span := channel.fanout(client, "PRIVMSG")
+	defer span.End()
+
+	channel.sendMessage(client, messageReplies(PRIVMSG, client, channel, message), tags, "")
 }
 
 func (channel *Channel) applyModeFlag(client *Client, mode ChannelMode,
@@ -277,6 +445,10 @@ func (channel *Channel) applyModeFlag(client *Client, mode ChannelMode,
 			return false
 		}
 		channel.flags.Set(mode)
+		if mode == Secret || mode == Private {
+			// from now on counted under "*", see countMessage
+			channel.server.metrics.CounterVec("channel", "messages").DeleteLabelValues(channel.name.String())
+		}
 		return true
 
 	case Remove:
@@ -331,22 +503,25 @@ func (channel *Channel) applyModeMember(client *Client, mode ChannelMode,
 }
 
 func (channel *Channel) ShowMaskList(client *Client, mode ChannelMode) {
-	for lmask := range channel.lists[mode].masks {
-		client.RplMaskList(mode, channel, lmask)
-	}
+	now := time.Now()
+	channel.lists[mode].Range(func(lmask *UserMask) bool {
+		if !lmask.Expired(now) {
+			client.RplMaskList(mode, channel, lmask)
+		}
+		return true
+	})
 	client.RplEndOfMaskList(mode, channel)
 }
 
-func (channel *Channel) applyModeMask(client *Client, mode ChannelMode, op ModeOp,
-	mask Name) bool {
-	list := channel.lists[mode]
+func (channel *Channel) applyModeMask(client *Client, change *ChannelModeChange) bool {
+	list := channel.lists[change.mode]
 	if list == nil {
 		// This should never happen, but better safe than panicky.
 		return false
 	}
 
-	if (op == List) || (mask == "") {
-		channel.ShowMaskList(client, mode)
+	if (change.op == List) || (change.arg == "") {
+		channel.ShowMaskList(client, change.mode)
 		return false
 	}
 
@@ -355,12 +530,20 @@ func (channel *Channel) applyModeMask(client *Client, mode ChannelMode, op ModeO
 		return false
 	}
 
-	if op == Add {
-		return list.Add(mask)
+	// the change goes out with the mask as it is stored
+	usermask, err := NewUserMask(NewName(change.arg))
+	if err != nil {
+		client.ErrInvalidModeParam(channel, change.mode, change.arg, err.Error())
+		return false
+	}
+	change.arg = usermask.mask.String()
+
+	if change.op == Add {
+		return channel.addMask(client, change.mode, usermask)
 	}
 
-	if op == Remove {
-		return list.Remove(mask)
+	if change.op == Remove {
+		return list.Remove(usermask.mask)
 	}
 
 	return false
@@ -368,11 +551,11 @@ func (channel *Channel) applyModeMask(client *Client, mode ChannelMode, op ModeO
 
 func (channel *Channel) applyMode(client *Client, change *ChannelModeChange) bool {
 	switch change.mode {
-	case BanMask, ExceptMask, InviteMask:
-		return channel.applyModeMask(client, change.mode, change.op,
-			NewName(change.arg))
+	case BanMask, ExceptMask, InviteMask, QuietMask:
+		return channel.applyModeMask(client, change)
 
-	case InviteOnly, Moderated, NoOutside, OpOnlyTopic, Private, Secret, SecureChan:
+	case InviteOnly, Moderated, NoOutside, OpOnlyTopic, Private, Secret, SecureChan,
+		NoColors, StripColors, NoCTCP, RegisteredOnly, RegisteredSpeak, NoNickChange:
 		return channel.applyModeFlag(client, change.mode, change.op)
 
 	case Key:
@@ -401,17 +584,84 @@ func (channel *Channel) applyMode(client *Client, change *ChannelModeChange) boo
 		}
 
 	case UserLimit:
-		limit, err := strconv.ParseUint(change.arg, 10, 64)
-		if err != nil {
-			client.ErrNeedMoreParams("MODE")
+		if !channel.ClientIsOperator(client) {
+			client.ErrChanOPrivIsNeeded(channel)
 			return false
 		}
-		if (limit == 0) || (limit == channel.userLimit) {
+
+		switch change.op {
+		case Add:
+			limit, err := strconv.ParseUint(change.arg, 10, 64)
+			if err != nil {
+				client.ErrNeedMoreParams("MODE")
+				return false
+			}
+			if (limit == 0) || (limit == channel.userLimit) {
+				return false
+			}
+
+			channel.userLimit = limit
+			return true
+
+		case Remove:
+			if channel.userLimit == 0 {
+				return false
+			}
+			channel.userLimit = 0
+			return true
+		}
+
+	case JoinThrottle:
+		if !channel.ClientIsOperator(client) {
+			client.ErrChanOPrivIsNeeded(channel)
 			return false
 		}
 
-		channel.userLimit = limit
-		return true
+		switch change.op {
+		case Add:
+			limit, err := ParseJoinThrottle(change.arg)
+			if err != nil {
+				client.ErrNeedMoreParams("MODE")
+				return false
+			}
+			channel.joinThrottle = limit
+			change.arg = limit.String()
+			return true
+
+		case Remove:
+			if channel.joinThrottle == nil {
+				return false
+			}
+			channel.joinThrottle = nil
+			return true
+		}
+
+	case Redirect:
+		if !channel.ClientIsOperator(client) {
+			client.ErrChanOPrivIsNeeded(channel)
+			return false
+		}
+
+		switch change.op {
+		case Add:
+			target := NewName(change.arg)
+			if !target.IsChannel() || target.ToLower() == channel.name.ToLower() {
+				client.ErrNoSuchChannel(target)
+				return false
+			}
+			if target == channel.redirect {
+				return false
+			}
+			channel.redirect = target
+			return true
+
+		case Remove:
+			if channel.redirect == "" {
+				return false
+			}
+			channel.redirect = ""
+			return true
+		}
 
 	case ChannelOperator, Voice:
 		return channel.applyModeMember(client, change.mode, change.op,
@@ -445,30 +695,83 @@ func (channel *Channel) Mode(client *Client, changes ChannelModeChanges) {
 	}
 }
 
-func (channel *Channel) Notice(client *Client, message Text) {
+func (channel *Channel) Notice(client *Client, message Text, tags Tags) {
+	if !channel.CanSpeak(client) {
+		client.ErrCannotSendToChan(channel)
+		return
+	}
+	message, mode := channel.FilterMessage(client, message)
+	if mode != 0 {
+		client.ErrCannotSendToChanMode(channel, mode)
+		return
+	}
+	span := channel.fanout(client, "NOTICE")
+	defer span.End()
+
+	channel.sendMessage(client, messageReplies(NOTICE, client, channel, message), tags, "")
+}
+
+// TagMsg relays a TAGMSG, which only members with message-tags can be sent.
+func (channel *Channel) TagMsg(client *Client, tags Tags) {
 	if !channel.CanSpeak(client) {
 		client.ErrCannotSendToChan(channel)
 		return
 	}
-	reply := RplNotice(client, channel, message)
+	span := channel.fanout(client, "TAGMSG")
+	defer span.End()
+
+	channel.sendMessage(client, []string{RplTagMsg(client, channel)}, tags, MessageTags)
+}
+
+// sendMessage delivers a message from client to the other members that
+// enabled capability, if one is given, and back to client if it asked for
+// echo-message.
+func (channel *Channel) sendMessage(client *Client, replies []string, tags Tags, capability Capability) {
+	channel.countMessage()
+
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+		if capability != "" && !member.capabilities[capability] {
+			return true
+		}
 		if member == client {
+			if client.capabilities[EchoMessage] {
+				client.ReplyLinesWithTags(replies, tags)
+			}
 			return true
 		}
 		client.server.metrics.Counter("client", "messages").Inc()
-		member.Reply(reply)
+		member.ReplyLinesWithTags(replies, tags)
 		return true
 	})
 }
 
+// fanout starts a span around delivering a message to every member.
+func (channel *Channel) fanout(client *Client, command string) trace.Span {
+	_, span := channel.server.tracing.Start(client.ctx, "fanout "+command,
+		attrChannel.String(channel.name.String()),
+		attrRecipients.Int(channel.members.Count()),
+	)
+	return span
+}
+
+// countMessage counts a message sent to the channel. Secret and private
+// channels are counted together under "*", so that their names don't
+// show up on the metrics endpoint.
+func (channel *Channel) countMessage() {
+	label := channel.name.String()
+	if channel.flags.Has(Secret) || channel.flags.Has(Private) {
+		label = "*"
+	}
+	channel.server.metrics.CounterVec("channel", "messages").WithLabelValues(label).Inc()
+}
+
 func (channel *Channel) Quit(client *Client) {
 	channel.members.Remove(client)
-	// XXX: Race Condition from client.destroy()
-	//      Do we need to?
-	// client.channels.Remove(channel)
+	client.channels.Remove(channel)
 
 	if channel.IsEmpty() {
 		channel.server.channels.Remove(channel)
+		channel.server.metrics.CounterVec("channel", "messages").DeleteLabelValues(channel.name.String())
 	}
 }
 
@@ -505,13 +808,26 @@ func (channel *Channel) Invite(invitee *Client, inviter *Client) {
 		return
 	}
 
+	// only operators can invite to a +i channel, so only those invites
+	// count; elsewhere any member could invite a banned user past +b
 	if channel.flags.Has(InviteOnly) {
-		channel.lists[InviteMask].Add(invitee.UserHost(false))
+		invitee.invites[channel] = time.Now().Add(channel.server.config.InviteTimeout())
 	}
 
+	reply := RplInviteMsg(inviter, invitee, channel.name)
+	tags := inviter.Tags()
 	inviter.RplInviting(invitee, channel.name)
-	invitee.Reply(RplInviteMsg(inviter, invitee, channel.name))
+	invitee.ReplyWithTags(reply, tags)
 	if invitee.modes.Has(Away) {
 		inviter.RplAway(invitee)
 	}
+
+	// let the other operators know, they could have invited too
+	channel.members.Range(func(member *Client, modes *ChannelModeSet) bool {
+		if member != inviter && member != invitee &&
+			modes.Has(ChannelOperator) && member.capabilities[InviteNotify] {
+			member.ReplyWithTags(reply, tags)
+		}
+		return true
+	})
 }
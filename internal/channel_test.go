@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestJoinPart(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Expect(`^:irc.test.net 353 alice = #test :@alice$`)
+	alice.Expect(`^:irc.test.net 366 alice #test `)
+
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	bob.Expect(`^:irc.test.net 353 bob = #test :(@alice bob|bob @alice)$`)
+
+	bob.Send("PART #test :later")
+	alice.Expect(`^:bob!\S+ PART #test :later$`)
+	bob.Expect(`^:bob!\S+ PART #test :later$`)
+
+	bob.Send("PART #test")
+	bob.Expect(`^:irc.test.net 442 bob #test `)
+
+	// the channel goes away with its last member
+	alice.Send("PART #test")
+	alice.Expect(`^:alice!\S+ PART #test`)
+	bob.Send("JOIN #test")
+	bob.Expect(`^:irc.test.net 353 bob = #test :@bob$`)
+}
+
+func TestKick(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+
+	bob.Send("KICK #test alice :no")
+	bob.Expect(`^:irc.test.net 482 bob #test `)
+
+	alice.Send("KICK #test bob :out")
+	alice.Expect(`^:alice!\S+ KICK #test bob :out$`)
+	bob.Expect(`^:alice!\S+ KICK #test bob :out$`)
+
+	alice.Send("KICK #test bob")
+	alice.Expect(`^:irc.test.net 441 alice bob #test `)
+}
+
+func TestChannelModes(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test +i")
+	alice.Expect(`^:alice!\S+ MODE #test \+i$`)
+	bob.Send("JOIN #test")
+	bob.Expect(`^:irc.test.net 473 bob #test `)
+
+	alice.Send("INVITE bob #test")
+	bob.Expect(`^:alice!\S+ INVITE bob :#test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test -i")
+	alice.Expect(`^:alice!\S+ MODE #test -i$`)
+	alice.Send("MODE #test +m")
+	alice.Expect(`^:alice!\S+ MODE #test \+m$`)
+	bob.Send("PRIVMSG #test :hello")
+	bob.Expect(`^:irc.test.net 404 bob #test `)
+
+	alice.Send("MODE #test +v bob")
+	bob.Expect(`^:alice!\S+ MODE #test \+v bob$`)
+	bob.Send("PRIVMSG #test :hello")
+	alice.Expect(`^:bob!\S+ PRIVMSG #test :hello$`)
+
+	bob.Send("MODE #test +k key")
+	bob.Expect(`^:irc.test.net 482 bob #test `)
+
+	// bob's invite exempts him from bans, carol has none
+	carol := server.Register("carol")
+	alice.Send("MODE #test +kb key carol!*@*")
+	alice.Expect(`^:alice!\S+ MODE #test \+kb key carol!\*@\*$`)
+
+	carol.Send("JOIN #test")
+	carol.Expect(`^:irc.test.net 475 carol #test `)
+	carol.Send("JOIN #test key")
+	carol.Expect(`^:irc.test.net 474 carol #test `)
+
+	alice.Send("MODE #test -b carol!*@*")
+	alice.Expect(`^:alice!\S+ MODE #test -b carol!\*@\*$`)
+	carol.Send("JOIN #test key")
+	alice.Expect(`^:carol!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test")
+	alice.Expect(`^:irc.test.net 324 alice #test \+kmnt key$`)
+}
+
+func TestQuietMask(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test +q bob!*@*")
+	alice.Expect(`^:alice!\S+ MODE #test \+q bob!\*@\*$`)
+	alice.Send("MODE #test q")
+	alice.Expect(`^:irc.test.net 728 alice #test q bob!\*@\* alice!\S+ \d+$`)
+	alice.Expect(`^:irc.test.net 729 alice #test q :End of channel quiet list$`)
+
+	// quieted members may still join, but not speak unless voiced
+	bob.Send("PRIVMSG #test :hello")
+	bob.Expect(`^:irc.test.net 404 bob #test `)
+	alice.Send("MODE #test +v bob")
+	bob.Expect(`^:alice!\S+ MODE #test \+v bob$`)
+	bob.Send("PRIVMSG #test :hello")
+	alice.Expect(`^:bob!\S+ PRIVMSG #test :hello$`)
+}
+
+func TestJoinThrottle(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	carol := server.Register("carol")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("MODE #test +j 1:60")
+	alice.Expect(`^:alice!\S+ MODE #test \+j 1:60$`)
+	alice.Send("MODE #test")
+	alice.Expect(`^:irc.test.net 324 alice #test \+jnt 1:60$`)
+
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	carol.Send("JOIN #test")
+	carol.Expect(`^:irc.test.net 437 carol #test `)
+
+	alice.Send("MODE #test -j")
+	alice.Expect(`^:alice!\S+ MODE #test -j$`)
+	carol.Send("JOIN #test")
+	alice.Expect(`^:carol!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test +j 0:60")
+	alice.Expect(`^:irc.test.net 461 alice MODE `)
+}
+
+func TestMessageModes(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test +S")
+	alice.Expect(`^:alice!\S+ MODE #test \+S$`)
+	bob.Send("PRIVMSG #test :\x0304,12red\x03 and \x02bold")
+	alice.Expect(`^:bob!\S+ PRIVMSG #test :red and \x02bold$`)
+
+	alice.Send("MODE #test +cC")
+	alice.Expect(`^:alice!\S+ MODE #test \+cC$`)
+	bob.Send("PRIVMSG #test :\x0304red")
+	bob.Expect(`^:irc.test.net 404 bob #test :Cannot send to channel \(\+c\)$`)
+	bob.Send("PRIVMSG #test :\x01VERSION\x01")
+	bob.Expect(`^:irc.test.net 404 bob #test :Cannot send to channel \(\+C\)$`)
+	bob.Send("PRIVMSG #test :\x01ACTION waves\x01")
+	alice.Expect(`^:bob!\S+ PRIVMSG #test :\x01ACTION waves\x01$`)
+
+	// channel operators are exempt
+	alice.Send("NOTICE #test :\x0304red")
+	bob.Expect(`^:alice!\S+ NOTICE #test :\x0304red$`)
+}
+
+func TestRegisteredModes(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.Account = map[string]*AccountConfig{
+			"carol": {PassConfig: PassConfig{Password: testPassword(t, "secret")}},
+		}
+	})
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test +MR")
+	alice.Expect(`^:alice!\S+ MODE #test \+MR$`)
+	bob.Send("PRIVMSG #test :hello")
+	bob.Expect(`^:irc.test.net 404 bob #test `)
+
+	carol := server.Connect()
+	carol.Send("CAP REQ :sasl")
+	carol.Send("AUTHENTICATE PLAIN")
+	carol.Send("AUTHENTICATE %s", saslPlain("carol", "secret"))
+	carol.Expect(` 903 `)
+	carol.Send("NICK carol")
+	carol.Send("USER carol 0 * :Carol")
+	carol.Send("CAP END")
+	carol.Expect(` 001 carol `)
+
+	dave := server.Register("dave")
+	dave.Send("JOIN #test")
+	dave.Expect(`^:irc.test.net 477 dave #test `)
+	carol.Send("JOIN #test")
+	alice.Expect(`^:carol!\S+ JOIN #test$`)
+	carol.Send("PRIVMSG #test :hello")
+	alice.Expect(`^:carol!\S+ PRIVMSG #test :hello$`)
+}
+
+func TestNoNickChange(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	alice.Send("MODE #test +N")
+	alice.Expect(`^:alice!\S+ MODE #test \+N$`)
+
+	bob.Send("NICK robert")
+	bob.Expect(`^:irc.test.net 447 bob #test `)
+	alice.Send("NICK alicia")
+	alice.Expect(`^:alice!\S+ NICK :?alicia$`)
+}
+
+func TestRedirect(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("MODE #test +l 1")
+	alice.Expect(`^:alice!\S+ MODE #test \+l 1$`)
+	alice.Send("MODE #test +L #test")
+	alice.Expect(`^:irc.test.net 403 alice #test `)
+	alice.Send("MODE #test +L #overflow")
+	alice.Expect(`^:alice!\S+ MODE #test \+L #overflow$`)
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:irc.test.net 470 bob #test #overflow `)
+	bob.Expect(`^:bob!\S+ JOIN #overflow$`)
+
+	alice.Send("MODE #test -l")
+	alice.Expect(`^:alice!\S+ MODE #test -l$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+}
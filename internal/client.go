@@ -1,20 +1,29 @@
 package internal
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/common/log"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	IDLE_TIMEOUT = time.Minute // how long before a client is considered idle
-	QUIT_TIMEOUT = time.Minute // how long after idle before a client is kicked
+	IDLE_TIMEOUT  = time.Minute      // how long before a client is considered idle
+	QUIT_TIMEOUT  = time.Minute      // how long after idle before a client is kicked
+	FLUSH_TIMEOUT = 10 * time.Second // how long a quitting client has to read its sendq
 )
 
+// MaxRealnameLen is the longest realname SETNAME accepts.
+const MaxRealnameLen = 128
+
 type SyncBool struct {
 	sync.RWMutex
 
@@ -43,29 +52,56 @@ type Client struct {
 	atime        time.Time
 	authorized   bool
 	awayMessage  Text
+	batchID      uint64
 	capabilities CapabilitySet
 	capState     CapState
+	capVersion   int
 	channels     *ChannelSet
+	certfp       string // TLS client certificate fingerprint
 	ctime        time.Time
+	accepted     map[*Client]bool // ACCEPT list
+	acceptedBy   map[*Client]bool // clients with this one on their ACCEPT list
+	acceptNotice time.Time        // last told someone blocked by +g messaged
+	flood        *FloodControl
+	invites      map[*Channel]time.Time // pending invites, until they expire
+	knocks       *FloodControl          // limits KNOCK
 	modes        *UserModeSet
+	operClass    *OperClass    // set by OPER
+	operThrottle *FloodControl // limits OPER attempts
+	monitoring   map[Name]Name // casefolded nick to the nick as given
+	silence      *UserMaskSet
+	multiline    *multilineBatch
 	hasQuit      *SyncBool
 	hops         uint
 	hostname     Name
-	hostmask     Name // Cloacked hostname (SHA256)
+	hostmask     Name   // Cloaked hostname, or the vhost if one is set
+	cloaks       []Name // Keyed cloaks of hostname, current secret first
+	ip           net.IP
 	pingTime     time.Time
 	idleTimer    *time.Timer
 	nick         Name
 	quitTimer    *time.Timer
 	realname     Text
 	registered   bool
+	response     *labeledResponse
 	sasl         *SaslState
 	server       *Server
 	socket       *Socket
-	replies      chan string
+	sendQ        *SendQueue
+	transport    Transport
 	username     Name
+	vhost        Name
+
+	// Tracing: the connection's span, and the span of the command being
+	// handled, which is what anything done on the client's behalf hangs
+	// off. ctx is only used on the server goroutine.
+	connCtx  context.Context
+	ctx      context.Context
+	connSpan trace.Span
+	regSpan  trace.Span
 }
 
-func NewClient(server *Server, conn net.Conn) *Client {
+func NewClient(server *Server, conn net.Conn, transport Transport) *Client {
 	now := time.Now()
 	c := &Client{
 		atime:        now,
@@ -74,18 +110,33 @@ func NewClient(server *Server, conn net.Conn) *Client {
 		capabilities: make(CapabilitySet),
 		channels:     NewChannelSet(),
 		ctime:        now,
+		accepted:     make(map[*Client]bool),
+		acceptedBy:   make(map[*Client]bool),
+		flood:        NewFloodControl(server.config.Server.Flood),
+		invites:      make(map[*Channel]time.Time),
+		knocks:       NewFloodControl(server.config.KnockThrottle()),
 		modes:        NewUserModeSet(),
+		monitoring:   make(map[Name]Name),
+		silence:      NewUserMaskSet(),
+		operThrottle: NewFloodControl(server.config.OperThrottle()),
 		hasQuit:      NewSyncBool(false),
 		sasl:         NewSaslState(),
 		server:       server,
 		socket:       NewSocket(conn),
-		replies:      make(chan string),
+		sendQ:        NewSendQueue(server.config.SendQ()),
+		transport:    transport,
 	}
+	server.conns[c] = true
 
 	if _, ok := conn.(*tls.Conn); ok {
 		c.modes.Set(SecureConn)
 	}
 
+	c.connCtx, c.connSpan = server.tracing.Start(context.Background(), "connection",
+		attrRemoteAddr.String(conn.RemoteAddr().String()))
+	_, c.regSpan = server.tracing.Start(c.connCtx, "register")
+	c.ctx = c.connCtx
+
 	c.Touch()
 	go c.writeloop()
 	go c.readloop()
@@ -98,14 +149,16 @@ func NewClient(server *Server, conn net.Conn) *Client {
 //
 
 func (c *Client) writeloop() {
+	// the socket is closed here rather than in destroy so that whatever was
+	// queued before the client quit (ERROR, a final numeric) is still sent
+	defer c.socket.Close()
+
 	for {
-		select {
-		case reply, ok := <-c.replies:
-			if !ok || reply == "" || c.socket == nil {
-				return
-			}
-			c.socket.Write(reply)
+		reply, ok := c.sendQ.Pop()
+		if !ok {
+			return
 		}
+		c.socket.Write(reply)
 	}
 }
 
@@ -113,29 +166,46 @@ func (c *Client) readloop() {
 	var command Command
 	var err error
 	var line string
-
-	// Set the hostname for this client.
+	// AUTHENTICATE chunks seen so far, see AuthenticateCommand.CheckPlain
+	var saslChunks strings.Builder
+
+	// Set the hostname for this client. NewClient has already added it to
+	// server.conns, so TRACE, STATS l and the connection timeout can reach
+	// it now; they only use the socket and fields NewClient set. Everything
+	// that reads the fields below finds the client through a command of its
+	// own or its nick, which comes after this.
+	c.ip = net.ParseIP(IPString(c.socket.conn.RemoteAddr()).String())
+	if conn, ok := c.socket.conn.(*tls.Conn); ok {
+		// a failed handshake fails the first read as well
+		if err := conn.Handshake(); err == nil {
+			c.certfp = CertFP(conn)
+		}
+	}
+	_, span := c.server.tracing.Start(c.connCtx, "dns")
 	c.hostname = AddrLookupHostname(c.socket.conn.RemoteAddr())
-	c.hostmask = NewName(SHA256(c.hostname.String()))
+	span.End()
+	c.cloaks = c.server.Cloaker().Cloaks(c.ip, c.hostname)
+	c.hostmask = c.cloaks[0]
 
 	for err == nil {
-		if line, err = c.socket.Read(); err != nil {
+		if line, err = c.socket.Read(); err == nil {
+			err = checkInputLen(line)
+		}
+
+		if err == ErrInputTooLong {
+			// the line is dropped, but the connection carries on
+			command, err = NewParseErrorCommand(ErrInputTooLong), nil
+
+		} else if err != nil {
 			command = NewQuitCommand("connection closed")
 
 		} else if command, err = ParseCommand(line); err != nil {
-			switch err {
-			case ErrParseCommand:
-				//TODO(dan): use the real failed numeric for this (400)
-				c.Reply(RplNotice(c.server, c, NewText("failed to parse command")))
-
-			case NotEnoughArgsError:
-				// TODO
-			}
-			// so the read loop will continue
+			// command is a ParseErrorCommand, which reports err
 			err = nil
-			continue
 
 		} else if checkPass, ok := command.(checkPasswordCommand); ok {
+			_, span := c.server.tracing.Start(c.connCtx, "password",
+				attrCommand.String(command.Code().String()))
 			checkPass.LoadPassword(c.server)
 			// Block the client thread while handling a potentially expensive
 			// password bcrypt operation. Since the server is single-threaded
@@ -143,22 +213,70 @@ func (c *Client) readloop() {
 			// blocking anyone else from sending commands until it
 			// completes. This could be a form of DoS if handled naively.
 			checkPass.CheckPassword()
+			span.End()
+
+		} else if auth, ok := command.(*AuthenticateCommand); ok {
+			// SASL PLAIN is bcrypt too, for the same reason
+			_, span := c.server.tracing.Start(c.connCtx, "password",
+				attrCommand.String(command.Code().String()))
+			auth.CheckPlain(c.server.accounts, &saslChunks)
+			span.End()
 		}
 
-		c.processCommand(command)
+		c.queueCommand(command)
+		if _, ok := command.(*QuitCommand); ok {
+			// nothing sent after QUIT is handled
+			return
+		}
 	}
 }
 
-func (c *Client) processCommand(cmd Command) {
+// queueCommand hands cmd to the server goroutine, which owns all client
+// and channel state. It is safe to call from any goroutine except the
+// server goroutine itself.
+func (c *Client) queueCommand(cmd Command) {
 	cmd.SetClient(c)
+	select {
+	case c.server.commands <- cmd:
+	case <-c.server.done:
+	}
+}
+
+//
+// server goroutine
+//
+
+func (c *Client) processCommand(cmd Command) {
+	// the readloop may have queued more commands before the client quit
+	// or was killed
+	if c.hasQuit.Get() {
+		return
+	}
+
+	ctx, span := c.server.tracing.StartLinked(c.connCtx, "command "+cmd.Code().String(),
+		attrCommand.String(cmd.Code().String()),
+		attrNick.String(c.nick.String()),
+	)
+	c.ctx = ctx
+	defer func() {
+		span.End()
+		c.ctx = c.connCtx
+	}()
+
+	if label := cmd.Label(); label != "" && c.capabilities[LabeledResponse] {
+		c.response = &labeledResponse{label: label}
+		defer c.sendLabeledResponse()
+	}
 
 	if !c.registered {
-		regCmd, ok := cmd.(RegServerCommand)
-		if !ok {
-			c.Quit("unexpected command")
-			return
+		switch cmd := cmd.(type) {
+		case RegServerCommand:
+			cmd.HandleRegServer(c.server)
+		case *UnknownCommand:
+			c.ErrUnknownCommand(cmd.Code())
+		default:
+			c.ErrNotRegistered()
 		}
-		regCmd.HandleRegServer(c.server)
 		return
 	}
 
@@ -169,6 +287,8 @@ func (c *Client) processCommand(cmd Command) {
 	}
 
 	c.server.metrics.Counter("client", "commands").Inc()
+	c.server.metrics.CounterVec("client", "command_calls").WithLabelValues(cmd.Code().String()).Inc()
+	c.server.commandUsage[cmd.Code()]++
 
 	defer func(t time.Time) {
 		v := c.server.metrics.SummaryVec("client", "command_duration_seconds")
@@ -187,13 +307,18 @@ func (c *Client) processCommand(cmd Command) {
 		c.Touch()
 	}
 
+	if countsAsMessage(srvCmd) && !c.flood.Allow(time.Now()) {
+		c.Quit("Excess Flood")
+		return
+	}
+
 	srvCmd.HandleServer(c.server)
 }
 
 // quit timer goroutine
 
 func (c *Client) connectionTimeout() {
-	c.processCommand(NewQuitCommand("connection timeout"))
+	c.queueCommand(NewQuitCommand("connection timeout"))
 }
 
 //
@@ -201,7 +326,10 @@ func (c *Client) connectionTimeout() {
 //
 
 func (c *Client) connectionIdle() {
-	c.server.idle <- c
+	select {
+	case c.server.idle <- c:
+	case <-c.server.done:
+	}
 }
 
 //
@@ -242,15 +370,17 @@ func (c *Client) Register() {
 	c.registered = true
 	c.modes.Set(HostMask)
 	c.Touch()
+
+	c.regSpan.SetAttributes(attrNick.String(c.nick.String()))
+	c.regSpan.End()
 }
 
 func (c *Client) destroy() {
 	// clean up channels
 
-	c.channels.Range(func(channel *Channel) bool {
+	for _, channel := range c.Channels() {
 		channel.Quit(c)
-		return true
-	})
+	}
 
 	// clean up server
 
@@ -261,8 +391,15 @@ func (c *Client) destroy() {
 	}
 
 	c.server.connections.Dec()
+	delete(c.server.conns, c)
 	c.server.clients.Remove(c)
 
+	if !c.registered {
+		c.regSpan.SetStatus(codes.Error, "disconnected before registering")
+		c.regSpan.End()
+	}
+	c.connSpan.End()
+
 	// clean up self
 
 	if c.idleTimer != nil {
@@ -272,9 +409,10 @@ func (c *Client) destroy() {
 		c.quitTimer.Stop()
 	}
 
-	close(c.replies)
-
-	c.socket.Close()
+	// the writeloop closes the socket once the sendq is drained; don't let
+	// a client that stopped reading hold on to it
+	c.sendQ.Close()
+	c.socket.conn.SetWriteDeadline(time.Now().Add(FLUSH_TIMEOUT))
 
 	log.Debugf("%s: destroyed", c)
 }
@@ -313,16 +451,64 @@ func (c *Client) ModeString() (str string) {
 }
 
 func (c *Client) UserHost(cloacked bool) Name {
-	username := "*"
-	if c.username != "" {
-		username = c.username.String()
-	}
+	username := c.Username()
 	if cloacked {
 		return Name(fmt.Sprintf("%s!%s@%s", c.nick, username, c.hostmask))
 	}
 	return Name(fmt.Sprintf("%s!%s@%s", c.nick, username, c.hostname))
 }
 
+// UserHosts returns every mask the client can be matched by: its real
+// host, its cloak or vhost, and its cloaks under previous secrets.
+func (c *Client) UserHosts() []Name {
+	userhosts := []Name{c.UserHost(false), c.UserHost(true)}
+	for _, cloak := range c.cloaks {
+		if cloak == c.hostmask {
+			continue
+		}
+		userhosts = append(userhosts, Name(fmt.Sprintf("%s!%s@%s", c.nick, c.username, cloak)))
+	}
+	return userhosts
+}
+
+// SetVHost replaces the client's cloak with vhost, or restores the cloak
+// if vhost is empty.
+func (c *Client) SetVHost(vhost Name) {
+	hostmask := c.hostmask
+	if vhost != "" {
+		hostmask = vhost
+	} else if len(c.cloaks) > 0 {
+		hostmask = c.cloaks[0]
+	}
+
+	// made before the change, so it carries the old hostmask
+	reply := RplChgHost(c, c.Username(), hostmask)
+
+	c.vhost = vhost
+	if hostmask == c.hostmask {
+		return
+	}
+	c.hostmask = hostmask
+
+	if c.registered && c.capabilities[ChgHost] {
+		c.ReplyWithTags(reply, c.Tags())
+	}
+	c.notifyFriends(ChgHost, reply)
+}
+
+// Username returns the client's username, or * before it has sent USER.
+func (c *Client) Username() Name {
+	if c.username == "" {
+		return "*"
+	}
+	return c.username
+}
+
+// Account returns the account the client is logged in to, if any.
+func (c *Client) Account() string {
+	return c.sasl.Id()
+}
+
 func (c *Client) Server() Name {
 	return c.server.name
 }
@@ -346,6 +532,17 @@ func (c *Client) String() string {
 	return c.Id().String()
 }
 
+// Channels returns a snapshot of the client's channels, safe to iterate
+// while the client joins or parts.
+func (c *Client) Channels() []*Channel {
+	channels := make([]*Channel, 0, c.channels.Count())
+	c.channels.Range(func(channel *Channel) bool {
+		channels = append(channels, channel)
+		return true
+	})
+	return channels
+}
+
 func (c *Client) Friends() *ClientSet {
 	friends := NewClientSet()
 	friends.Add(c)
@@ -373,17 +570,77 @@ func (c *Client) ChangeNickname(nickname Name) {
 	reply := RplNick(c, nickname)
 	c.server.clients.Remove(c)
 	c.server.whoWas.Append(c)
+	// watchers only hear about registered clients; tryRegister announces
+	// the others
+	if c.registered {
+		c.server.monitorOffline(c.nick)
+	}
+	if nickname.ToLower() != c.nick.ToLower() {
+		c.dropAccepts()
+	}
 	c.nick = nickname
 	c.server.clients.Add(c)
+	if c.registered {
+		c.monitorOnline()
+	}
+
+	friends := c.Friends()
+	_, span := c.server.tracing.Start(c.ctx, "fanout NICK",
+		attrRecipients.Int(friends.Count()))
+	defer span.End()
+	tags := c.Tags()
+	friends.Range(func(friend *Client) bool {
+		friend.ReplyWithTags(reply, tags)
+		return true
+	})
+}
+
+// notifyFriends sends reply, tagged as coming from the client, to every
+// friend that enabled capability. The client itself is left out.
+func (c *Client) notifyFriends(capability Capability, reply string) {
+	tags := c.Tags()
 	c.Friends().Range(func(friend *Client) bool {
-		friend.Reply(reply)
+		if friend != c && friend.capabilities[capability] {
+			friend.ReplyWithTags(reply, tags)
+		}
 		return true
 	})
 }
 
+// Reply queues reply for the client without blocking. A client that falls
+// too far behind is disconnected instead of stalling whoever is sending.
 func (c *Client) Reply(reply string) {
-	if !c.hasQuit.Get() {
-		c.replies <- reply
+	c.ReplyWithTags(reply, nil)
+}
+
+// ReplyWithTags queues reply with the tags the client has enabled.
+func (c *Client) ReplyWithTags(reply string, tags Tags) {
+	if c.hasQuit.Get() {
+		return
+	}
+	if c.response != nil {
+		c.response.replies = append(c.response.replies, taggedReply{reply, tags})
+		return
+	}
+	reply = frameLine(tags.For(c), reply)
+	if err := c.sendQ.Push(reply); err == ErrSendQExceeded {
+		log.Debugf("%s: %s", c, err)
+		c.server.metrics.Counter("client", "sendq_exceeded").Inc()
+		go c.queueCommand(NewQuitCommand(NewText(err.Error())))
+	}
+}
+
+// ReplyLinesWithTags queues the lines a long message was split into. They
+// share its tags, except that each line after the first gets the msgid
+// suffixed with its index, so that every line has its own.
+func (c *Client) ReplyLinesWithTags(replies []string, tags Tags) {
+	msgid, ok := tags["msgid"]
+	for index, reply := range replies {
+		if ok && index > 0 {
+			c.ReplyWithTags(reply, tags.with("msgid", msgid+"-"+strconv.Itoa(index)))
+			continue
+		}
+		c.ReplyWithTags(reply, tags)
 	}
 }
 
@@ -392,17 +649,35 @@ func (c *Client) Quit(message Text) {
 		return
 	}
 
+	// sent before hasQuit is set, which makes Reply drop everything, and
+	// outside any labeled response, which would never be sent
+	c.response = nil
+	c.Reply(RplError(message.String()))
 	c.hasQuit.Set(true)
-	c.Reply(RplError("quit"))
-	c.server.whoWas.Append(c)
+	if c.HasNick() {
+		c.server.whoWas.Append(c)
+	}
+	c.server.monitors.RemoveAll(c)
+	c.dropAccepts()
+	for other := range c.accepted {
+		delete(other.acceptedBy, c)
+	}
+	if c.registered {
+		c.server.monitorOffline(c.nick)
+	}
 	friends := c.Friends()
 	friends.Remove(c)
 	c.destroy()
 
 	if friends.Count() > 0 {
+		_, span := c.server.tracing.Start(c.ctx, "fanout QUIT",
+			attrRecipients.Int(friends.Count()))
+		defer span.End()
+
 		reply := RplQuit(c, message)
+		tags := c.Tags()
 		friends.Range(func(friend *Client) bool {
-			friend.Reply(reply)
+			friend.ReplyWithTags(reply, tags)
 			return true
 		})
 	}
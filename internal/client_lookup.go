@@ -2,9 +2,10 @@ package internal
 
 import (
 	"errors"
-	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/goshuirc/irc-go/ircmatch"
 )
@@ -131,94 +132,110 @@ func (clients *ClientLookupSet) Find(userhost Name) *Client {
 }
 
 //
-// usermask to regexp
+// user mask lists
 //
 
+// UserMaskSet is a channel's ban, exception, invite or quiet list. Each
+// mask is parsed once, when it is added.
 type UserMaskSet struct {
-	masks  map[Name]bool
-	regexp *regexp.Regexp
+	masks map[Name]*UserMask
 }
 
 func NewUserMaskSet() *UserMaskSet {
 	return &UserMaskSet{
-		masks: make(map[Name]bool),
+		masks: make(map[Name]*UserMask),
 	}
 }
 
-func (set *UserMaskSet) Add(mask Name) bool {
-	if set.masks[mask] {
+func (set *UserMaskSet) Count() int {
+	return len(set.masks)
+}
+
+// Add adds usermask, unless the same mask is already in the set.
+func (set *UserMaskSet) Add(usermask *UserMask) bool {
+	if set.masks[usermask.Key()] != nil {
 		return false
 	}
-	set.masks[mask] = true
-	set.setRegexp()
+	set.masks[usermask.Key()] = usermask
 	return true
 }
 
 func (set *UserMaskSet) AddAll(masks []Name) (added bool) {
 	for _, mask := range masks {
-		if !added && !set.masks[mask] {
+		usermask, err := NewUserMask(mask)
+		if err == nil && set.Add(usermask) {
 			added = true
 		}
-		set.masks[mask] = true
 	}
-	set.setRegexp()
 	return
 }
 
 func (set *UserMaskSet) Remove(mask Name) bool {
-	if !set.masks[mask] {
+	usermask, err := NewUserMask(mask)
+	if err != nil {
 		return false
 	}
-	delete(set.masks, mask)
-	set.setRegexp()
-	return true
+	return set.remove(set.masks[usermask.Key()])
 }
 
-func (set *UserMaskSet) Match(userhost Name) bool {
-	if set.regexp == nil {
+// remove removes usermask, if it is still in the set, and stops its expiry.
+func (set *UserMaskSet) remove(usermask *UserMask) bool {
+	if usermask == nil || set.masks[usermask.Key()] != usermask {
 		return false
 	}
-	return set.regexp.MatchString(userhost.String())
+	if usermask.timer != nil {
+		usermask.timer.Stop()
+	}
+	delete(set.masks, usermask.Key())
+	return true
 }
 
-func (set *UserMaskSet) String() string {
-	masks := make([]string, len(set.masks))
-	index := 0
-	for mask := range set.masks {
-		masks[index] = mask.String()
-		index += 1
+// Range calls f for each mask, in the order they were set.
+func (set *UserMaskSet) Range(f func(mask *UserMask) bool) {
+	masks := make([]*UserMask, 0, len(set.masks))
+	for _, mask := range set.masks {
+		masks = append(masks, mask)
+	}
+	sort.Slice(masks, func(i, j int) bool { return masks[i].setAt.Before(masks[j].setAt) })
+	for _, mask := range masks {
+		if !f(mask) {
+			return
+		}
 	}
-	return strings.Join(masks, " ")
 }
 
-// Generate a regular expression from the set of user mask
-// strings. Masks are split at the two types of wildcards, `*` and
-// `?`. All the pieces are meta-escaped. `*` is replaced with `.*`,
-// the regexp equivalent. Likewise, `?` is replaced with `.`. The
-// parts are re-joined and finally all masks are joined into a big
-// or-expression.
-func (set *UserMaskSet) setRegexp() {
+// Match reports whether any mask in the set matches client.
+func (set *UserMaskSet) Match(client *Client) bool {
+	return set.Find(client) != nil
+}
+
+// Find returns a mask in the set that matches client, or nil.
+func (set *UserMaskSet) Find(client *Client) *UserMask {
+	return set.find(client, true)
+}
+
+func (set *UserMaskSet) find(client *Client, followChannels bool) *UserMask {
 	if len(set.masks) == 0 {
-		set.regexp = nil
-		return
-	}
-
-	maskExprs := make([]string, len(set.masks))
-	index := 0
-	for mask := range set.masks {
-		manyParts := strings.Split(mask.String(), "*")
-		manyExprs := make([]string, len(manyParts))
-		for mindex, manyPart := range manyParts {
-			oneParts := strings.Split(manyPart, "?")
-			oneExprs := make([]string, len(oneParts))
-			for oindex, onePart := range oneParts {
-				oneExprs[oindex] = regexp.QuoteMeta(onePart)
-			}
-			manyExprs[mindex] = strings.Join(oneExprs, ".")
+		return nil
+	}
+	now := time.Now()
+	userhosts := client.UserHosts()
+	for index, userhost := range userhosts {
+		userhosts[index] = userhost.ToLower()
+	}
+	for _, mask := range set.masks {
+		// an expired mask may not have been removed yet
+		if !mask.Expired(now) && mask.Match(client, userhosts, followChannels) {
+			return mask
 		}
-		maskExprs[index] = strings.Join(manyExprs, ".*")
 	}
-	expr := "^" + strings.Join(maskExprs, "|") + "$"
-	set.regexp, _ = regexp.Compile(expr)
+	return nil
 }
 
+func (set *UserMaskSet) String() string {
+	masks := make([]string, 0, len(set.masks))
+	for _, mask := range set.masks {
+		masks = append(masks, mask.mask.String())
+	}
+	return strings.Join(masks, " ")
+}
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// DefaultCloakSuffix is appended to cloaked IP addresses.
+	DefaultCloakSuffix = "ip"
+
+	// number of hex digits used for each cloak segment
+	cloakSegmentLen = 8
+)
+
+var (
+	// CIDR buckets hashed into each IP cloak, narrowest first. Clients in
+	// the same network share the trailing segments of their cloaks, so a
+	// ban on `*!*@*.SEG2.SEG3.ip` covers the whole /24 (or /64).
+	CloakIPv4Buckets = []int{32, 24, 16}
+	CloakIPv6Buckets = []int{128, 64, 48}
+)
+
+// Cloaker derives stable, keyed cloaks for client hosts. The first secret
+// is used for the cloak clients are shown with; any further secrets are
+// previous ones, kept so that bans on old cloaks keep matching while a
+// secret is being rotated out.
+type Cloaker struct {
+	secrets [][]byte
+	suffix  string
+}
+
+func NewCloaker(secrets []string, suffix string) *Cloaker {
+	cloaker := newCloaker(secrets, suffix)
+	if len(cloaker.secrets) == 0 {
+		log.Warnf("no cloaking secret configured, using a random one; cloaks will change on restart")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("unable to generate cloaking secret: %s", err)
+		}
+		cloaker.secrets = append(cloaker.secrets, secret)
+	}
+
+	return cloaker
+}
+
+// Rekey returns a cloaker for the secrets and suffix of a reloaded config.
+// Without any secrets it keeps the current ones, so that a random secret
+// lasts until the server restarts.
+func (cloaker *Cloaker) Rekey(secrets []string, suffix string) *Cloaker {
+	rekeyed := newCloaker(secrets, suffix)
+	if len(rekeyed.secrets) == 0 {
+		rekeyed.secrets = cloaker.secrets
+	}
+	return rekeyed
+}
+
+func newCloaker(secrets []string, suffix string) *Cloaker {
+	cloaker := &Cloaker{suffix: suffix}
+	if cloaker.suffix == "" {
+		cloaker.suffix = DefaultCloakSuffix
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		cloaker.secrets = append(cloaker.secrets, []byte(secret))
+	}
+	return cloaker
+}
+
+// Cloak returns the current cloak for a client connecting from ip with the
+// resolved hostname. ip may be nil for transports without one (I2P, Tor).
+func (cloaker *Cloaker) Cloak(ip net.IP, hostname Name) Name {
+	return cloaker.cloak(cloaker.secrets[0], ip, hostname)
+}
+
+// Cloaks returns the cloaks for every configured secret, current first.
+func (cloaker *Cloaker) Cloaks(ip net.IP, hostname Name) []Name {
+	cloaks := make([]Name, len(cloaker.secrets))
+	for index, secret := range cloaker.secrets {
+		cloaks[index] = cloaker.cloak(secret, ip, hostname)
+	}
+	return cloaks
+}
+
+func (cloaker *Cloaker) cloak(secret []byte, ip net.IP, hostname Name) Name {
+	host := hostname.String()
+
+	// an unresolved IP comes back from LookupHostname unchanged
+	if ip != nil && (host == "" || net.ParseIP(host) != nil) {
+		return cloaker.cloakIP(secret, ip)
+	}
+
+	if IsHostname(host) {
+		labels := strings.Split(host, ".")
+		if len(labels) > 2 {
+			// keep the domain, hide the machine
+			labels[0] = cloakHash(secret, host)
+			return NewName(strings.Join(labels, "."))
+		}
+	}
+
+	return NewName(cloakHash(secret, host) + "." + cloaker.suffix)
+}
+
+func (cloaker *Cloaker) cloakIP(secret []byte, ip net.IP) Name {
+	buckets := CloakIPv6Buckets
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		buckets = CloakIPv4Buckets
+	}
+
+	segments := make([]string, 0, len(buckets)+1)
+	for _, bits := range buckets {
+		network := net.IPNet{
+			IP:   ip.Mask(net.CIDRMask(bits, len(ip)*8)),
+			Mask: net.CIDRMask(bits, len(ip)*8),
+		}
+		segments = append(segments, cloakHash(secret, network.String()))
+	}
+	segments = append(segments, cloaker.suffix)
+
+	return NewName(strings.Join(segments, "."))
+}
+
+func cloakHash(secret []byte, data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	sum := hex.EncodeToString(mac.Sum(nil))
+	return strings.ToUpper(sum[:cloakSegmentLen])
+}
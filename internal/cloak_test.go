@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloakIP(t *testing.T) {
+	assert := assert.New(t)
+
+	cloaker := NewCloaker([]string{"secret"}, "")
+
+	a := cloaker.Cloak(net.ParseIP("192.0.2.10"), "192.0.2.10").String()
+	b := cloaker.Cloak(net.ParseIP("192.0.2.20"), "192.0.2.20").String()
+	c := cloaker.Cloak(net.ParseIP("198.51.100.10"), "198.51.100.10").String()
+
+	assert.Equal(a, cloaker.Cloak(net.ParseIP("192.0.2.10"), "192.0.2.10").String())
+	assert.NotContains(a, "192")
+	assert.True(strings.HasSuffix(a, ".ip"))
+
+	// same /24: only the /32 segment differs
+	assert.NotEqual(a, b)
+	assert.Equal(a[strings.Index(a, "."):], b[strings.Index(b, "."):])
+
+	// different /16: nothing in common but the suffix
+	assert.NotEqual(strings.Split(a, ".")[2], strings.Split(c, ".")[2])
+
+	v6 := cloaker.Cloak(net.ParseIP("2001:db8::1"), "2001:db8::1").String()
+	assert.Len(strings.Split(v6, "."), 4)
+}
+
+func TestCloakHostname(t *testing.T) {
+	assert := assert.New(t)
+
+	cloaker := NewCloaker([]string{"secret"}, "")
+	ip := net.ParseIP("192.0.2.10")
+
+	cloak := cloaker.Cloak(ip, "host-192-0-2-10.isp.example.com").String()
+	assert.True(strings.HasSuffix(cloak, ".isp.example.com"))
+	assert.NotContains(cloak, "192")
+
+	assert.True(strings.HasSuffix(cloaker.Cloak(nil, "example.com").String(), ".ip"))
+}
+
+func TestCloakSecrets(t *testing.T) {
+	assert := assert.New(t)
+
+	ip := net.ParseIP("192.0.2.10")
+	old := NewCloaker([]string{"old"}, "")
+	rotated := NewCloaker([]string{"new", "old"}, "")
+
+	assert.NotEqual(old.Cloak(ip, "192.0.2.10"), rotated.Cloak(ip, "192.0.2.10"))
+	assert.Equal(
+		[]Name{rotated.Cloak(ip, "192.0.2.10"), old.Cloak(ip, "192.0.2.10")},
+		rotated.Cloaks(ip, "192.0.2.10"),
+	)
+}
+
+func TestCloakRekey(t *testing.T) {
+	assert := assert.New(t)
+
+	ip := net.ParseIP("192.0.2.10")
+	random := NewCloaker(nil, "")
+
+	// a random secret survives a rehash that still configures none
+	assert.Equal(random.Cloak(ip, "192.0.2.10"), random.Rekey(nil, "").Cloak(ip, "192.0.2.10"))
+	assert.Equal(
+		NewCloaker([]string{"new"}, "").Cloak(ip, "192.0.2.10"),
+		random.Rekey([]string{"new"}, "").Cloak(ip, "192.0.2.10"),
+	)
+}
@@ -2,18 +2,22 @@ package internal
 
 
 import (
-	"errors"
+	"encoding/base64"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Command interface {
 	Client() *Client
 	Code() StringCode
+	Label() string
 	SetClient(*Client)
 	SetCode(StringCode)
+	SetTags(Tags)
+	Tags() Tags
 }
 
 type checkPasswordCommand interface {
@@ -23,20 +27,44 @@ type checkPasswordCommand interface {
 
 type parseCommandFunc func([]string) (Command, error)
 
+// ParseError is an error parsing a line from a client, with the numeric
+// that reports it.
+type ParseError struct {
+	numeric NumericCode
+	message string
+	// whether the numeric names the command, as ERR_NEEDMOREPARAMS does
+	hasCommand bool
+}
+
+func (err *ParseError) Error() string {
+	return err.message
+}
+
+var (
+	NotEnoughArgsError = &ParseError{ERR_NEEDMOREPARAMS, "Not enough parameters", true}
+	ErrParseCommand    = &ParseError{ERR_UNKNOWNERROR, "Failed to parse message", true}
+	ErrInputTooLong    = &ParseError{ERR_INPUTTOOLONG, "Input line was too long", false}
+	ErrNoNicknameGiven = &ParseError{ERR_NONICKNAMEGIVEN, "No nickname given", false}
+)
+
 var (
-	NotEnoughArgsError = errors.New("not enough arguments")
-	ErrParseCommand    = errors.New("failed to parse message")
 	parseCommandFuncs  = map[StringCode]parseCommandFunc{
+		ACCEPT:       ParseAcceptCommand,
 		AUTHENTICATE: ParseAuthenticateCommand,
 		AWAY:         ParseAwayCommand,
+		BATCH:        ParseBatchCommand,
 		CAP:          ParseCapCommand,
+		CHECK:        ParseCheckCommand,
+		FILTER:       ParseFilterCommand,
 		INVITE:       ParseInviteCommand,
 		ISON:         ParseIsOnCommand,
 		JOIN:         ParseJoinCommand,
 		KICK:         ParseKickCommand,
+		KNOCK:        ParseKnockCommand,
 		KILL:         ParseKillCommand,
 		LIST:         ParseListCommand,
 		MODE:         ParseModeCommand,
+		MONITOR:      ParseMonitorCommand,
 		MOTD:         ParseMOTDCommand,
 		NAMES:        ParseNamesCommand,
 		NICK:         ParseNickCommand,
@@ -44,17 +72,24 @@ var (
 		ONICK:        ParseOperNickCommand,
 		OPER:         ParseOperCommand,
 		REHASH:       ParseRehashCommand,
+		SETNAME:      ParseSetNameCommand,
+		SILENCE:      ParseSilenceCommand,
+		STATS:        ParseStatsCommand,
 		PART:         ParsePartCommand,
 		PASS:         ParsePassCommand,
 		PING:         ParsePingCommand,
 		PONG:         ParsePongCommand,
 		PRIVMSG:      ParsePrivMsgCommand,
 		QUIT:         ParseQuitCommand,
+		TAGMSG:       ParseTagMsgCommand,
+		TBAN:         ParseTBanCommand,
 		TIME:         ParseTimeCommand,
 		LUSERS:       ParseLUsersCommand,
 		TOPIC:        ParseTopicCommand,
+		TRACE:        ParseTraceCommand,
 		USER:         ParseUserCommand,
 		VERSION:      ParseVersionCommand,
+		VHOST:        ParseVHostCommand,
 		WALLOPS:      ParseWallopsCommand,
 		WHO:          ParseWhoCommand,
 		WHOIS:        ParseWhoisCommand,
@@ -65,6 +100,7 @@ var (
 type BaseCommand struct {
 	client *Client
 	code   StringCode
+	tags   Tags
 }
 
 func (command *BaseCommand) Client() *Client {
@@ -83,7 +119,28 @@ func (command *BaseCommand) SetCode(code StringCode) {
 	command.code = code
 }
 
+// Tags are the message tags the client sent with the command, or nil.
+func (command *BaseCommand) Tags() Tags {
+	return command.tags
+}
+
+func (command *BaseCommand) SetTags(tags Tags) {
+	command.tags = tags
+}
+
+// Label is the labeled-response label the client sent with the command.
+func (command *BaseCommand) Label() string {
+	return command.tags["label"]
+}
+
 func ParseCommand(line string) (cmd Command, err error) {
+	var tags Tags
+	if strings.HasPrefix(line, "@") {
+		var raw string
+		raw, line = splitArg(line[len("@"):])
+		tags = ParseTags(raw)
+	}
+
 	code, args := ParseLine(line)
 	constructor := parseCommandFuncs[code]
 	if constructor == nil {
@@ -91,9 +148,15 @@ func ParseCommand(line string) (cmd Command, err error) {
 	} else {
 		cmd, err = constructor(args)
 	}
-	if cmd != nil {
-		cmd.SetCode(code)
+	if err != nil {
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			parseErr = &ParseError{ERR_UNKNOWNERROR, err.Error(), true}
+		}
+		cmd = NewParseErrorCommand(parseErr)
 	}
+	cmd.SetCode(code)
+	cmd.SetTags(tags)
 	return
 }
 
@@ -143,6 +206,20 @@ func ParseUnknownCommand(args []string) *UnknownCommand {
 	}
 }
 
+// ParseErrorCommand stands in for a line that couldn't be parsed, so that
+// the error is reported from the server goroutine like any other reply.
+
+type ParseErrorCommand struct {
+	BaseCommand
+	err *ParseError
+}
+
+func NewParseErrorCommand(err *ParseError) *ParseErrorCommand {
+	cmd := &ParseErrorCommand{err: err}
+	cmd.SetCode("*")
+	return cmd
+}
+
 // PING <server1> [ <server2> ]
 
 type PingCommand struct {
@@ -190,6 +267,39 @@ func ParsePongCommand(args []string) (Command, error) {
 type AuthenticateCommand struct {
 	BaseCommand
 	arg string
+	// the PLAIN payload checked on the readloop, and the result
+	plain []byte
+	err   error
+}
+
+// CheckPlain runs on the client's readloop, which collects the chunks of a
+// PLAIN payload the way the server goroutine does, and checks the password
+// once the last chunk arrives.
+func (cmd *AuthenticateCommand) CheckPlain(accounts PasswordStore, chunks *strings.Builder) {
+	switch {
+	case cmd.arg == "*":
+		chunks.Reset()
+		return
+	case len(cmd.arg) > 400:
+		return
+	case len(cmd.arg) == 400:
+		chunks.WriteString(cmd.arg)
+		return
+	case cmd.arg != "+":
+		chunks.WriteString(cmd.arg)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(chunks.String())
+	chunks.Reset()
+	if err != nil {
+		return
+	}
+	authcid, password, reason := parseSaslPlain(data)
+	if reason != "" {
+		return
+	}
+	cmd.plain = data
+	cmd.err = accounts.Verify(authcid, password)
 }
 
 func ParseAuthenticateCommand(args []string) (Command, error) {
@@ -233,8 +343,8 @@ func ParsePassCommand(args []string) (Command, error) {
 // NICK <nickname>
 
 func ParseNickCommand(args []string) (Command, error) {
-	if len(args) != 1 {
-		return nil, NotEnoughArgsError
+	if len(args) < 1 || args[0] == "" {
+		return nil, ErrNoNicknameGiven
 	}
 	return &NickCommand{
 		nickname: NewName(args[0]),
@@ -404,6 +514,88 @@ func ParsePrivMsgCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// BATCH +<ref> <type> [params...]
+// BATCH -<ref>
+
+type BatchCommand struct {
+	BaseCommand
+	ref       string
+	start     bool
+	batchType string
+	params    []string
+}
+
+func ParseBatchCommand(args []string) (Command, error) {
+	if len(args) < 1 || len(args[0]) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &BatchCommand{
+		ref:   args[0][1:],
+		start: args[0][0] == '+',
+	}
+	if !cmd.start {
+		if args[0][0] != '-' {
+			return nil, ErrParseCommand
+		}
+		return cmd, nil
+	}
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd.batchType = args[1]
+	cmd.params = args[2:]
+	return cmd, nil
+}
+
+// MONITOR <+|-> <target>[,<target>...]
+// MONITOR <C|L|S>
+
+type MonitorCommand struct {
+	BaseCommand
+	subCommand string
+	targets    []Name
+}
+
+func ParseMonitorCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &MonitorCommand{
+		subCommand: strings.ToUpper(args[0]),
+	}
+	switch cmd.subCommand {
+	case "+", "-":
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		for _, target := range strings.Split(args[1], ",") {
+			if target != "" {
+				cmd.targets = append(cmd.targets, NewName(target))
+			}
+		}
+	case "C", "L", "S":
+	default:
+		return nil, ErrParseCommand
+	}
+	return cmd, nil
+}
+
+// TAGMSG <target>
+
+type TagMsgCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseTagMsgCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &TagMsgCommand{
+		target: NewName(args[0]),
+	}, nil
+}
+
 // TOPIC [newtopic]
 
 type TopicCommand struct {
@@ -562,13 +754,18 @@ func ParseChannelModeCommand(channel Name, args []string) (Command, error) {
 				mode: ChannelMode(mode),
 				op:   op,
 			}
+			takesArg := false
 			switch change.mode {
-			case Key, BanMask, ExceptMask, InviteMask, UserLimit,
+			case Key, BanMask, ExceptMask, InviteMask, QuietMask,
 				ChannelOperator, ChannelCreator, Voice:
-				if len(args) > skipArgs {
-					change.arg = args[skipArgs]
-					skipArgs += 1
-				}
+				takesArg = true
+			case UserLimit, JoinThrottle, Redirect:
+				// only take a parameter when set
+				takesArg = op == Add
+			}
+			if takesArg && len(args) > skipArgs {
+				change.arg = args[skipArgs]
+				skipArgs += 1
 			}
 			cmd.changes = append(cmd.changes, change)
 		}
@@ -623,9 +820,12 @@ type WhoCommand struct {
 	BaseCommand
 	mask         Name
 	operatorOnly bool
+	whox         bool
+	fields       string // WHOX fields requested
+	token        string // WHOX querytype, echoed back
 }
 
-// WHO [ <mask> [ "o" ] ]
+// WHO [ <mask> [ "o" ] [ "%" <fields> [ "," <token> ] ] ]
 func ParseWhoCommand(args []string) (Command, error) {
 	cmd := &WhoCommand{}
 
@@ -633,20 +833,45 @@ func ParseWhoCommand(args []string) (Command, error) {
 		cmd.mask = NewName(args[0])
 	}
 
-	if (len(args) > 1) && (args[1] == "o") {
-		cmd.operatorOnly = true
+	if len(args) > 1 {
+		flags, whox, isWhox := strings.Cut(args[1], "%")
+		cmd.operatorOnly = strings.Contains(flags, "o")
+		if isWhox {
+			cmd.whox = true
+			cmd.fields, cmd.token, _ = strings.Cut(whox, ",")
+			if !isWhoxToken(cmd.token) {
+				cmd.token = ""
+			}
+		}
 	}
 
 	return cmd, nil
 }
 
+// isWhoxToken reports whether token is a valid querytype: 1 to 3 digits.
+func isWhoxToken(token string) bool {
+	if len(token) == 0 || len(token) > 3 {
+		return false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 type OperCommand struct {
 	PassCommand
 	name Name
+	oper *Oper
 }
 
 func (msg *OperCommand) LoadPassword(server *Server) {
-	msg.hash = server.operators[msg.name]
+	msg.oper = server.Oper(msg.name)
+	if msg.oper != nil {
+		msg.hash = msg.oper.password
+	}
 }
 
 // OPER <name> <password>
@@ -674,6 +899,8 @@ func ParseRehashCommand(args []string) (Command, error) {
 type CapCommand struct {
 	BaseCommand
 	subCommand   CapSubCommand
+	version      int
+	arg          string
 	capabilities CapabilitySet
 }
 
@@ -688,9 +915,18 @@ func ParseCapCommand(args []string) (Command, error) {
 	}
 
 	if len(args) > 1 {
-		strs := spacesExpr.Split(args[1], -1)
-		for _, str := range strs {
-			cmd.capabilities[Capability(str)] = true
+		cmd.arg = strings.TrimSpace(args[1])
+		if cmd.subCommand == CAP_LS {
+			cmd.version = parseCapVersion(cmd.arg)
+			return cmd, nil
+		}
+
+		for _, str := range strings.Fields(cmd.arg) {
+			if strings.HasPrefix(str, Disable.String()) {
+				cmd.capabilities[Capability(str[1:])] = false
+			} else {
+				cmd.capabilities[Capability(str)] = true
+			}
 		}
 	}
 	return cmd, nil
@@ -711,6 +947,104 @@ func ParseAwayCommand(args []string) (Command, error) {
 	return cmd, nil
 }
 
+// SETNAME :<realname>
+type SetNameCommand struct {
+	BaseCommand
+	realname Text
+}
+
+func ParseSetNameCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &SetNameCommand{
+		realname: NewText(args[0]),
+	}, nil
+}
+
+// VHOST ( SET <nick> <vhost> / CLEAR <nick> )
+// VHOST ( SETACCOUNT <account> <vhost> / CLEARACCOUNT <account> )
+type VHostCommand struct {
+	BaseCommand
+	subCommand string
+	target     Name
+	vhost      Name
+}
+
+func ParseVHostCommand(args []string) (Command, error) {
+	if len(args) < 2 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &VHostCommand{
+		subCommand: strings.ToUpper(args[0]),
+		target:     NewName(args[1]),
+	}
+	switch cmd.subCommand {
+	case "SET", "SETACCOUNT":
+		if len(args) < 3 {
+			return nil, NotEnoughArgsError
+		}
+		cmd.vhost = NewName(args[2])
+	case "CLEAR", "CLEARACCOUNT":
+	default:
+		return nil, ErrParseCommand
+	}
+	return cmd, nil
+}
+
+// FILTER ADD ( REGEXP / TEXT ) <scopes> <action>[:<duration>] <pattern> [<reason>]
+// FILTER DEL <pattern>
+// FILTER LIST
+type FilterCommand struct {
+	BaseCommand
+	subCommand string
+	filter     FilterConfig
+}
+
+func ParseFilterCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &FilterCommand{
+		subCommand: strings.ToUpper(args[0]),
+	}
+	switch cmd.subCommand {
+	case "ADD":
+		if len(args) < 5 {
+			return nil, NotEnoughArgsError
+		}
+		switch strings.ToUpper(args[1]) {
+		case "REGEXP":
+			cmd.filter.Regexp = true
+		case "TEXT":
+		default:
+			return nil, ErrParseCommand
+		}
+		cmd.filter.Scopes = strings.Split(args[2], ",")
+		action, duration, found := strings.Cut(args[3], ":")
+		cmd.filter.Action = action
+		if found {
+			var err error
+			if cmd.filter.Duration, err = ParseDuration(duration); err != nil {
+				return nil, ErrParseCommand
+			}
+		}
+		cmd.filter.Pattern = args[4]
+		if len(args) > 5 {
+			cmd.filter.Reason = args[5]
+		}
+	case "DEL":
+		if len(args) < 2 {
+			return nil, NotEnoughArgsError
+		}
+		cmd.filter.Pattern = args[1]
+	case "LIST":
+	default:
+		return nil, ErrParseCommand
+	}
+	return cmd, nil
+}
+
 type IsOnCommand struct {
 	BaseCommand
 	nicks []Name
@@ -840,6 +1174,7 @@ func ParseVersionCommand(args []string) (Command, error) {
 	return cmd, nil
 }
 
+// INVITE [ <nickname> <channel> ]
 type InviteCommand struct {
 	BaseCommand
 	nickname Name
@@ -847,6 +1182,10 @@ type InviteCommand struct {
 }
 
 func ParseInviteCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		// list the client's pending invites
+		return &InviteCommand{}, nil
+	}
 	if len(args) < 2 {
 		return nil, NotEnoughArgsError
 	}
@@ -857,6 +1196,71 @@ func ParseInviteCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// SILENCE [ ( "+" / "-" ) <mask> *( " " ( "+" / "-" ) <mask> ) ]
+type SilenceCommand struct {
+	BaseCommand
+	changes []SilenceChange
+}
+
+type SilenceChange struct {
+	op   ModeOp
+	mask Name
+}
+
+func ParseSilenceCommand(args []string) (Command, error) {
+	cmd := &SilenceCommand{}
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		change := SilenceChange{op: Add, mask: NewName(arg)}
+		if op := ModeOp(arg[0]); op == Add || op == Remove {
+			change.op = op
+			change.mask = NewName(arg[1:])
+		}
+		if change.mask == "" {
+			return nil, ErrParseCommand
+		}
+		cmd.changes = append(cmd.changes, change)
+	}
+	return cmd, nil
+}
+
+// ACCEPT ( "*" / <nick> *( "," [ "-" ] <nick> ) )
+type AcceptCommand struct {
+	BaseCommand
+	nicks []Name // "-" prefixed to remove
+}
+
+func ParseAcceptCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &AcceptCommand{
+		nicks: NewNames(strings.Split(args[0], ",")),
+	}, nil
+}
+
+// KNOCK <channel> [ <message> ]
+type KnockCommand struct {
+	BaseCommand
+	channel Name
+	message Text
+}
+
+func ParseKnockCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &KnockCommand{
+		channel: NewName(args[0]),
+	}
+	if len(args) > 1 {
+		cmd.message = NewText(args[1])
+	}
+	return cmd, nil
+}
+
 type TimeCommand struct {
 	BaseCommand
 	target Name
@@ -894,6 +1298,89 @@ func ParseKillCommand(args []string) (Command, error) {
 	}, nil
 }
 
+// STATS <query>
+type StatsCommand struct {
+	BaseCommand
+	query string
+}
+
+func ParseStatsCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &StatsCommand{
+		query: args[0],
+	}, nil
+}
+
+// TBAN <channel> [ <mode> ] <duration> <mask> [ <reason> ]
+type TBanCommand struct {
+	BaseCommand
+	channel  Name
+	mode     ChannelMode
+	duration time.Duration
+	mask     Name
+	reason   Text
+}
+
+func ParseTBanCommand(args []string) (Command, error) {
+	if len(args) < 3 {
+		return nil, NotEnoughArgsError
+	}
+	cmd := &TBanCommand{
+		channel: NewName(args[0]),
+		mode:    BanMask,
+	}
+	// the list defaults to bans, a duration is never a list mode
+	if mode := strings.TrimPrefix(args[1], "+"); len(mode) == 1 &&
+		strings.ContainsRune(ChannelModeTypes[0].String(), rune(mode[0])) {
+		cmd.mode = ChannelMode(mode[0])
+		args = args[1:]
+		if len(args) < 3 {
+			return nil, NotEnoughArgsError
+		}
+	}
+	duration, err := ParseDuration(args[1])
+	if err != nil {
+		return nil, ErrParseCommand
+	}
+	cmd.duration = duration
+	cmd.mask = NewName(args[2])
+	if len(args) > 3 {
+		cmd.reason = NewText(args[3])
+	}
+	return cmd, nil
+}
+
+// TRACE [ <nick> ]
+type TraceCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseTraceCommand(args []string) (Command, error) {
+	cmd := &TraceCommand{}
+	if len(args) > 0 {
+		cmd.target = NewName(args[0])
+	}
+	return cmd, nil
+}
+
+// CHECK <nick|channel>
+type CheckCommand struct {
+	BaseCommand
+	target Name
+}
+
+func ParseCheckCommand(args []string) (Command, error) {
+	if len(args) < 1 {
+		return nil, NotEnoughArgsError
+	}
+	return &CheckCommand{
+		target: NewName(args[0]),
+	}, nil
+}
+
 type WallopsCommand struct {
 	BaseCommand
 	message Text
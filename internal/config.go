@@ -2,14 +2,39 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"sync"
+	"time"
 
 	"dario.cat/mergo"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	DefaultMetricsListen = ":9314"
+	DefaultMetricsPath   = "/metrics"
+
+	DefaultMonitorLimit = 100
+
+	DefaultMaxList = 100
+
+	DefaultMultilineMaxBytes = 4096
+	DefaultMultilineMaxLines = 100
+
+	DefaultOperThrottleAttempts = 3
+	DefaultOperThrottlePeriod   = time.Minute
+
+	DefaultKnockThrottleKnocks = 1
+	DefaultKnockThrottlePeriod = time.Minute
+
+	DefaultInviteTimeout = time.Hour
+
+	DefaultSilenceLimit = 32
+	DefaultAcceptLimit  = 32
+)
+
 type PassConfig struct {
 	Password string
 }
@@ -31,6 +56,80 @@ type TorConfig struct {
 	Onion       string
 }
 
+type OperClassConfig struct {
+	Privileges []string
+}
+
+type OperConfig struct {
+	PassConfig `yaml:",inline"`
+	// Class names an OperClass; without one, the operator has every
+	// privilege.
+	Class string
+	// Hosts are the nick!user@host masks, matched against the real host
+	// or the IP, the operator may log in from; any host when empty.
+	Hosts []string
+	// Secure requires a TLS connection.
+	Secure bool
+	// CertFP is the SHA-256 fingerprint of the TLS client certificate the
+	// operator has to present, if set.
+	CertFP string
+	// Account is the account the operator has to be logged in to, if set.
+	Account string
+}
+
+type AccountConfig struct {
+	PassConfig `yaml:",inline"`
+	VHost      string
+}
+
+type MetricsConfig struct {
+	// Listen is the address of the standalone metrics listener,
+	// DefaultMetricsListen unless WWW is set; leave it empty with WWW
+	// set to only serve metrics on the WWW listeners.
+	Listen string
+	Path   string
+	// WWW also serves metrics on the WWW listeners under Path.
+	WWW bool
+}
+
+type TracingConfig struct {
+	Enabled bool
+	// Exporter is "otlp" (the default) or "stdout".
+	Exporter string
+	// Endpoint is the OTLP collector's gRPC address, host:port.
+	Endpoint string
+	Insecure bool
+	// Sample is the fraction of traces kept, from 0 to 1; all when unset.
+	Sample *float64
+}
+
+// SampleRatio returns the fraction of traces to sample.
+func (conf *TracingConfig) SampleRatio() float64 {
+	if conf.Sample == nil {
+		return 1
+	}
+	return *conf.Sample
+}
+
+type FloodConfig struct {
+	// Messages is how many messages a client may send per Period before
+	// it is disconnected; 0 turns flood control off.
+	Messages int
+	Period   time.Duration
+}
+
+type MultilineConfig struct {
+	MaxBytes int
+	MaxLines int
+}
+
+type CloakConfig struct {
+	// Secrets used to key host cloaks. The first one is current; list the
+	// old secret after a new one to rotate it out without voiding bans.
+	Secrets []string
+	Suffix  string
+}
+
 func (conf *PassConfig) PasswordBytes() []byte {
 	bytes, err := DecodePassword(conf.Password)
 	if err != nil {
@@ -40,7 +139,9 @@ func (conf *PassConfig) PasswordBytes() []byte {
 }
 
 type Config struct {
-	sync.Mutex
+	// not embedded: Reload merges a freshly loaded Config into this one,
+	// and mergo would copy an exported mutex over the held one
+	mu       sync.Mutex
 	filename string
 
 	Network struct {
@@ -57,6 +158,28 @@ type Config struct {
 		MOTD        string
 		Name        string
 		Description string
+		SendQ       int
+		Flood       FloodConfig
+		// MonitorLimit is how many nicks a client may MONITOR.
+		MonitorLimit int
+		// OperThrottle limits how often a connection may try OPER.
+		OperThrottle FloodConfig
+		// KnockThrottle limits how often a connection may KNOCK, and how
+		// often a channel may be knocked on.
+		KnockThrottle FloodConfig
+		// InviteTimeout is how long an invite stays good if unused.
+		InviteTimeout time.Duration
+		// SilenceLimit and AcceptLimit are how many entries a client's
+		// SILENCE and ACCEPT lists may hold.
+		SilenceLimit int
+		AcceptLimit  int
+		// MaxList is how many entries a channel's ban, exception, invite
+		// and quiet lists may hold together.
+		MaxList int
+		// FilterFile is where the FILTER list is saved, and read back
+		// from on start and REHASH. Without one, filters only last
+		// until the server restarts.
+		FilterFile string
 	}
 
 	WWW struct {
@@ -65,15 +188,57 @@ type Config struct {
 		I2PListen map[string]*I2PConfig
 		TorListen map[string]*TorConfig
 	}
-	Operator    map[string]*PassConfig
-	Account     map[string]*PassConfig
+	// Capabilities lists IRCv3 capabilities not to offer, by name.
+	Capabilities struct {
+		Disabled []string
+	}
+	Multiline   MultilineConfig
+	Metrics     MetricsConfig
+	Tracing     TracingConfig
+	Cloaking    CloakConfig
+	OperClass   map[string]*OperClassConfig
+	Operator    map[string]*OperConfig
+	Account     map[string]*AccountConfig
 	TemplateDir string
 }
 
-func (conf *Config) Operators() map[Name][]byte {
-	operators := make(map[Name][]byte)
+// OperClasses returns the operator classes by name. Unknown privileges are
+// left out; LoadConfig rejects them.
+func (conf *Config) OperClasses() map[string]*OperClass {
+	classes := make(map[string]*OperClass)
+	for name, classConf := range conf.OperClass {
+		var privileges []OperPrivilege
+		for _, str := range classConf.Privileges {
+			if privilege, err := ParseOperPrivilege(str); err == nil {
+				privileges = append(privileges, privilege)
+			}
+		}
+		classes[name] = NewOperClass(name, privileges)
+	}
+	return classes
+}
+
+func (conf *Config) Operators() map[Name]*Oper {
+	classes := conf.OperClasses()
+	operators := make(map[Name]*Oper)
 	for name, opConf := range conf.Operator {
-		operators[NewName(name)] = opConf.PasswordBytes()
+		class := DefaultOperClass
+		if opConf.Class != "" {
+			// an unknown class, which LoadConfig rejects, grants nothing
+			class = classes[opConf.Class]
+			if class == nil {
+				class = NewOperClass(opConf.Class, nil)
+			}
+		}
+		operators[NewName(name)] = &Oper{
+			name:     NewName(name),
+			password: opConf.PasswordBytes(),
+			class:    class,
+			hosts:    NewNames(opConf.Hosts),
+			secure:   opConf.Secure,
+			certfp:   NormalizeCertFP(opConf.CertFP),
+			account:  opConf.Account,
+		}
 	}
 	return operators
 }
@@ -86,24 +251,143 @@ func (conf *Config) Accounts() map[string][]byte {
 	return accounts
 }
 
+func (conf *Config) VHosts() map[string]Name {
+	vhosts := make(map[string]Name)
+	for name, account := range conf.Account {
+		if account.VHost != "" {
+			vhosts[name] = NewName(account.VHost)
+		}
+	}
+	return vhosts
+}
+
+// SendQ returns the per-client sendq limit in bytes.
+func (conf *Config) SendQ() int {
+	if conf.Server.SendQ > 0 {
+		return conf.Server.SendQ
+	}
+	return DefaultSendQ
+}
+
+// OperThrottle returns how many OPER attempts a connection may make per
+// period.
+func (conf *Config) OperThrottle() FloodConfig {
+	if conf.Server.OperThrottle.Messages > 0 && conf.Server.OperThrottle.Period > 0 {
+		return conf.Server.OperThrottle
+	}
+	return FloodConfig{
+		Messages: DefaultOperThrottleAttempts,
+		Period:   DefaultOperThrottlePeriod,
+	}
+}
+
+// KnockThrottle returns how many KNOCKs a connection may make, and a
+// channel may receive, per period.
+func (conf *Config) KnockThrottle() FloodConfig {
+	if conf.Server.KnockThrottle.Messages > 0 && conf.Server.KnockThrottle.Period > 0 {
+		return conf.Server.KnockThrottle
+	}
+	return FloodConfig{
+		Messages: DefaultKnockThrottleKnocks,
+		Period:   DefaultKnockThrottlePeriod,
+	}
+}
+
+// InviteTimeout returns how long an unused invite lasts.
+func (conf *Config) InviteTimeout() time.Duration {
+	if conf.Server.InviteTimeout > 0 {
+		return conf.Server.InviteTimeout
+	}
+	return DefaultInviteTimeout
+}
+
+// SilenceLimit returns how many masks a client may SILENCE.
+func (conf *Config) SilenceLimit() int {
+	if conf.Server.SilenceLimit > 0 {
+		return conf.Server.SilenceLimit
+	}
+	return DefaultSilenceLimit
+}
+
+// AcceptLimit returns how many nicks a client may ACCEPT.
+func (conf *Config) AcceptLimit() int {
+	if conf.Server.AcceptLimit > 0 {
+		return conf.Server.AcceptLimit
+	}
+	return DefaultAcceptLimit
+}
+
+// MetricsPath returns the HTTP path metrics are served under.
+func (conf *Config) MetricsPath() string {
+	if conf.Metrics.Path != "" {
+		return conf.Metrics.Path
+	}
+	return DefaultMetricsPath
+}
+
+// MonitorLimit returns how many nicks a client may MONITOR.
+func (conf *Config) MonitorLimit() int {
+	if conf.Server.MonitorLimit > 0 {
+		return conf.Server.MonitorLimit
+	}
+	return DefaultMonitorLimit
+}
+
+// MaxList returns how many entries a channel's lists may hold together.
+func (conf *Config) MaxList() int {
+	if conf.Server.MaxList > 0 {
+		return conf.Server.MaxList
+	}
+	return DefaultMaxList
+}
+
+// MultilineMaxBytes returns the most bytes of text a multiline batch may
+// carry.
+func (conf *Config) MultilineMaxBytes() int {
+	if conf.Multiline.MaxBytes > 0 {
+		return conf.Multiline.MaxBytes
+	}
+	return DefaultMultilineMaxBytes
+}
+
+// MultilineMaxLines returns the most lines a multiline batch may carry.
+func (conf *Config) MultilineMaxLines() int {
+	if conf.Multiline.MaxLines > 0 {
+		return conf.Multiline.MaxLines
+	}
+	return DefaultMultilineMaxLines
+}
+
 func (conf *Config) Name() string {
 	return conf.filename
 }
 
+func (conf *Config) Lock() {
+	conf.mu.Lock()
+}
+
+func (conf *Config) Unlock() {
+	conf.mu.Unlock()
+}
+
 func (conf *Config) Reload() error {
 	conf.Lock()
 	defer conf.Unlock()
 
 	newconf, err := LoadConfig(conf.filename)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	err = mergo.MergeWithOverwrite(conf, newconf)
 	if err != nil {
-		return nil
+		return err
 	}
 
+	// mergo leaves a value alone when the new one is empty, which would
+	// make it impossible to re-enable every capability
+	conf.Capabilities = newconf.Capabilities
+
 	return nil
 }
 
@@ -136,6 +420,25 @@ func LoadConfig(filename string) (config *Config, err error) {
 		return nil, errors.New("Server listening addresses missing")
 	}
 
+	for name, classConf := range config.OperClass {
+		for _, privilege := range classConf.Privileges {
+			if _, err := ParseOperPrivilege(privilege); err != nil {
+				return nil, fmt.Errorf("operator class %s: %s", name, err)
+			}
+		}
+	}
+
+	for name, opConf := range config.Operator {
+		if _, ok := config.OperClass[opConf.Class]; opConf.Class != "" && !ok {
+			return nil, fmt.Errorf("operator %s: unknown class %s", name, opConf.Class)
+		}
+	}
+
+	// served on the WWW listeners instead, if asked to
+	if config.Metrics.Listen == "" && !config.Metrics.WWW {
+		config.Metrics.Listen = DefaultMetricsListen
+	}
+
 	return config, nil
 }
 
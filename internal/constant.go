@@ -5,36 +5,55 @@ const (
 	MAX_REPLY_LEN = 512 - len(CRLF)
 
 	// string codes
+	ACCOUNT      StringCode = "ACCOUNT"
+	ACK          StringCode = "ACK"
+	ACCEPT       StringCode = "ACCEPT"
 	AUTHENTICATE StringCode = "AUTHENTICATE" // SASL
 	AWAY         StringCode = "AWAY"
+	BATCH        StringCode = "BATCH"
 	CAP          StringCode = "CAP"
+	CHECK        StringCode = "CHECK"
+	CHGHOST      StringCode = "CHGHOST"
 	ERROR        StringCode = "ERROR"
+	FAIL         StringCode = "FAIL"
+	FILTER       StringCode = "FILTER"
 	INVITE       StringCode = "INVITE"
 	ISON         StringCode = "ISON"
 	JOIN         StringCode = "JOIN"
+	KNOCK        StringCode = "KNOCK"
 	KICK         StringCode = "KICK"
 	KILL         StringCode = "KILL"
 	LIST         StringCode = "LIST"
 	MODE         StringCode = "MODE"
+	MONITOR      StringCode = "MONITOR"
 	MOTD         StringCode = "MOTD"
 	NAMES        StringCode = "NAMES"
 	NICK         StringCode = "NICK"
+	NOTE         StringCode = "NOTE"
 	NOTICE       StringCode = "NOTICE"
 	ONICK        StringCode = "ONICK"
 	OPER         StringCode = "OPER"
 	REHASH       StringCode = "REHASH"
+	SETNAME      StringCode = "SETNAME"
+	SILENCE      StringCode = "SILENCE"
+	STATS        StringCode = "STATS"
+	TBAN         StringCode = "TBAN"
 	PART         StringCode = "PART"
 	PASS         StringCode = "PASS"
 	PING         StringCode = "PING"
 	PONG         StringCode = "PONG"
 	PRIVMSG      StringCode = "PRIVMSG"
 	QUIT         StringCode = "QUIT"
+	TAGMSG       StringCode = "TAGMSG"
 	TIME         StringCode = "TIME"
 	LUSERS       StringCode = "LUSERS"
 	TOPIC        StringCode = "TOPIC"
+	TRACE        StringCode = "TRACE"
 	USER         StringCode = "USER"
 	VERSION      StringCode = "VERSION"
+	VHOST        StringCode = "VHOST"
 	WALLOPS      StringCode = "WALLOPS"
+	WARN         StringCode = "WARN"
 	WHO          StringCode = "WHO"
 	WHOIS        StringCode = "WHOIS"
 	WHOWAS       StringCode = "WHOWAS"
@@ -45,6 +64,7 @@ const (
 	RPL_CREATED           NumericCode = 3
 	RPL_MYINFO            NumericCode = 4
 	RPL_BOUNCE            NumericCode = 5
+	RPL_ISUPPORT          NumericCode = 5
 	RPL_TRACELINK         NumericCode = 200
 	RPL_TRACECONNECTING   NumericCode = 201
 	RPL_TRACEHANDSHAKE    NumericCode = 202
@@ -58,6 +78,8 @@ const (
 	RPL_TRACERECONNECT    NumericCode = 210
 	RPL_STATSLINKINFO     NumericCode = 211
 	RPL_STATSCOMMANDS     NumericCode = 212
+	RPL_STATSCLINE        NumericCode = 213
+	RPL_STATSKLINE        NumericCode = 216
 	RPL_ENDOFSTATS        NumericCode = 219
 	RPL_UMODEIS           NumericCode = 221
 	RPL_SERVLIST          NumericCode = 234
@@ -76,6 +98,10 @@ const (
 	RPL_TRACELOG          NumericCode = 261
 	RPL_TRACEEND          NumericCode = 262
 	RPL_TRYAGAIN          NumericCode = 263
+	RPL_SILELIST          NumericCode = 271
+	RPL_ENDOFSILELIST     NumericCode = 272
+	RPL_ACCEPTLIST        NumericCode = 281
+	RPL_ENDOFACCEPT       NumericCode = 282
 	RPL_AWAY              NumericCode = 301
 	RPL_USERHOST          NumericCode = 302
 	RPL_ISON              NumericCode = 303
@@ -94,6 +120,8 @@ const (
 	RPL_CHANNELMODEIS     NumericCode = 324
 	RPL_UNIQOPIS          NumericCode = 325
 	RPL_WHOISLOGGEDIN     NumericCode = 330
+	RPL_INVITEDLIST       NumericCode = 336
+	RPL_ENDOFINVITEDLIST  NumericCode = 337
 	RPL_NOTOPIC           NumericCode = 331
 	RPL_TOPIC             NumericCode = 332
 	RPL_INVITING          NumericCode = 341
@@ -104,6 +132,7 @@ const (
 	RPL_ENDOFEXCEPTLIST   NumericCode = 349
 	RPL_VERSION           NumericCode = 351
 	RPL_WHOREPLY          NumericCode = 352
+	RPL_WHOSPCRPL         NumericCode = 354
 	RPL_NAMREPLY          NumericCode = 353
 	RPL_LINKS             NumericCode = 364
 	RPL_ENDOFLINKS        NumericCode = 365
@@ -124,6 +153,7 @@ const (
 	RPL_USERS             NumericCode = 393
 	RPL_ENDOFUSERS        NumericCode = 394
 	RPL_NOUSERS           NumericCode = 395
+	ERR_UNKNOWNERROR      NumericCode = 400
 	ERR_NOSUCHNICK        NumericCode = 401
 	ERR_NOSUCHSERVER      NumericCode = 402
 	ERR_NOSUCHCHANNEL     NumericCode = 403
@@ -139,6 +169,7 @@ const (
 	ERR_NOTOPLEVEL        NumericCode = 413
 	ERR_WILDTOPLEVEL      NumericCode = 414
 	ERR_BADMASK           NumericCode = 415
+	ERR_INPUTTOOLONG      NumericCode = 417
 	ERR_UNKNOWNCOMMAND    NumericCode = 421
 	ERR_NOMOTD            NumericCode = 422
 	ERR_NOADMININFO       NumericCode = 423
@@ -154,6 +185,10 @@ const (
 	ERR_NOLOGIN           NumericCode = 444
 	ERR_SUMMONDISABLED    NumericCode = 445
 	ERR_USERSDISABLED     NumericCode = 446
+	ERR_NONICKCHANGE      NumericCode = 447
+	ERR_ACCEPTFULL        NumericCode = 456
+	ERR_ACCEPTEXIST       NumericCode = 457
+	ERR_ACCEPTNOT         NumericCode = 458
 	ERR_NOTREGISTERED     NumericCode = 451
 	ERR_NEEDMOREPARAMS    NumericCode = 461
 	ERR_ALREADYREGISTRED  NumericCode = 462
@@ -162,6 +197,7 @@ const (
 	ERR_YOUREBANNEDCREEP  NumericCode = 465
 	ERR_YOUWILLBEBANNED   NumericCode = 466
 	ERR_KEYSET            NumericCode = 467
+	ERR_LINKCHANNEL       NumericCode = 470
 	ERR_CHANNELISFULL     NumericCode = 471
 	ERR_UNKNOWNMODE       NumericCode = 472
 	ERR_INVITEONLYCHAN    NumericCode = 473
@@ -169,18 +205,46 @@ const (
 	ERR_BADCHANNELKEY     NumericCode = 475
 	ERR_BADCHANMASK       NumericCode = 476
 	ERR_NOCHANMODES       NumericCode = 477
+	ERR_NEEDREGGEDNICK    NumericCode = 477
 	ERR_BANLISTFULL       NumericCode = 478
 	ERR_NOPRIVILEGES      NumericCode = 481
 	ERR_CHANOPRIVSNEEDED  NumericCode = 482
 	ERR_CANTKILLSERVER    NumericCode = 483
 	ERR_RESTRICTED        NumericCode = 484
 	ERR_UNIQOPPRIVSNEEDED NumericCode = 485
+	ERR_NONONREG          NumericCode = 486
 	ERR_NOOPERHOST        NumericCode = 491
 	ERR_CANNOTSENDTOUSER  NumericCode = 492
 	ERR_UMODEUNKNOWNFLAG  NumericCode = 501
 	ERR_USERSDONTMATCH    NumericCode = 502
+	ERR_SILELISTFULL      NumericCode = 511
 	RPL_WHOISSECURE       NumericCode = 671
 
+	ERR_INVALIDMODEPARAM NumericCode = 696
+
+	// KNOCK
+	RPL_KNOCK        NumericCode = 710
+	RPL_KNOCKDLVR    NumericCode = 711
+	ERR_TOOMANYKNOCK NumericCode = 712
+	ERR_CHANOPEN     NumericCode = 713
+	ERR_KNOCKONCHAN  NumericCode = 714
+
+	// caller ID
+	RPL_TARGUMODEG NumericCode = 716
+	RPL_TARGNOTIFY NumericCode = 717
+	RPL_UMODEGMSG  NumericCode = 718
+
+	// quiet lists
+	RPL_QUIETLIST      NumericCode = 728
+	RPL_ENDOFQUIETLIST NumericCode = 729
+
+	// MONITOR
+	RPL_MONONLINE    NumericCode = 730
+	RPL_MONOFFLINE   NumericCode = 731
+	RPL_MONLIST      NumericCode = 732
+	RPL_ENDOFMONLIST NumericCode = 733
+	ERR_MONLISTFULL  NumericCode = 734
+
 	// SASL
 	RPL_LOGGEDIN    NumericCode = 900
 	RPL_LOGGEDOUT   NumericCode = 901
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/goshuirc/irc-go/ircmatch"
+)
+
+// ExtBanType is the letter after the '$' of an extended ban.
+type ExtBanType byte
+
+const (
+	ExtBanAccount  ExtBanType = 'a' // $a:<account>, or any logged in client
+	ExtBanChannel  ExtBanType = 'j' // $j:<channel>, clients banned there
+	ExtBanRealname ExtBanType = 'r' // $r:<realname>
+	ExtBanCertFP   ExtBanType = 'z' // $z:<certfp>, or any TLS client
+)
+
+var (
+	ExtBanTypes = []ExtBanType{
+		ExtBanAccount, ExtBanChannel, ExtBanRealname, ExtBanCertFP,
+	}
+
+	ErrExtBanType   = errors.New("unknown extended ban type")
+	ErrExtBanArg    = errors.New("extended ban needs an argument")
+	ErrExtBanTarget = errors.New("not a channel")
+)
+
+// ExtBanToken returns the EXTBAN RPL_ISUPPORT token.
+func ExtBanToken() string {
+	types := make([]byte, len(ExtBanTypes))
+	for index, kind := range ExtBanTypes {
+		types[index] = byte(kind)
+	}
+	return "EXTBAN=$," + string(types)
+}
+
+// ExtBan matches something about a client other than its nick!user@host:
+// $[~]<type>[:<arg>], where ~ negates the match.
+type ExtBan struct {
+	negate  bool
+	kind    ExtBanType
+	arg     string
+	matcher ircmatch.Matcher
+}
+
+func ParseExtBan(mask string) (*ExtBan, error) {
+	ext := &ExtBan{}
+	mask = strings.TrimPrefix(mask, "$")
+	if strings.HasPrefix(mask, "~") {
+		ext.negate = true
+		mask = mask[1:]
+	}
+	if mask == "" {
+		return nil, ErrExtBanType
+	}
+	ext.kind = ExtBanType(mask[0])
+	if rest := mask[1:]; rest != "" {
+		arg, found := strings.CutPrefix(rest, ":")
+		if !found || arg == "" {
+			return nil, ErrExtBanType
+		}
+		ext.arg = arg
+	}
+
+	switch ext.kind {
+	case ExtBanAccount, ExtBanRealname:
+		if ext.arg != "" {
+			ext.matcher = ircmatch.MakeMatch(strings.ToLower(ext.arg))
+		} else if ext.kind == ExtBanRealname {
+			return nil, ErrExtBanArg
+		}
+	case ExtBanChannel:
+		if ext.arg == "" {
+			return nil, ErrExtBanArg
+		}
+		if !Name(ext.arg).IsChannel() {
+			return nil, ErrExtBanTarget
+		}
+	case ExtBanCertFP:
+		ext.arg = NormalizeCertFP(ext.arg)
+	default:
+		return nil, ErrExtBanType
+	}
+	return ext, nil
+}
+
+func (ext *ExtBan) String() string {
+	str := "$"
+	if ext.negate {
+		str += "~"
+	}
+	str += string(ext.kind)
+	if ext.arg != "" {
+		str += ":" + ext.arg
+	}
+	return str
+}
+
+// Match reports whether the ban matches client. $j only follows plain
+// masks and other types in the channel it names, never another $j, so
+// that channels can't send each other round in circles.
+func (ext *ExtBan) Match(client *Client, followChannels bool) bool {
+	matched := false
+	switch ext.kind {
+	case ExtBanAccount:
+		account := client.Account()
+		if ext.arg == "" {
+			matched = account != ""
+		} else {
+			matched = account != "" && ext.matcher.Match(strings.ToLower(account))
+		}
+	case ExtBanRealname:
+		matched = ext.matcher.Match(strings.ToLower(client.realname.String()))
+	case ExtBanCertFP:
+		if ext.arg == "" {
+			matched = client.modes.Has(SecureConn)
+		} else {
+			matched = client.certfp != "" && client.certfp == ext.arg
+		}
+	case ExtBanChannel:
+		if !followChannels {
+			return false
+		}
+		if channel := client.server.channels.Get(Name(ext.arg)); channel != nil {
+			matched = channel.lists[BanMask].find(client, false) != nil &&
+				channel.lists[ExceptMask].find(client, false) == nil
+		}
+	}
+	return matched != ext.negate
+}
+
+// UserMask is an entry of a ban, exception, invite or quiet list: a
+// nick!user@host glob or an extended ban, with who set it and when.
+type UserMask struct {
+	mask    Name
+	matcher ircmatch.Matcher // unused for extended bans
+	ext     *ExtBan
+	setter  Name
+	setAt   time.Time
+	expires time.Time // zero if it never expires
+	reason  Text
+	timer   *time.Timer // removes the mask when it expires
+}
+
+// NewUserMask parses mask, filling in the missing parts of a host mask.
+func NewUserMask(mask Name) (*UserMask, error) {
+	if strings.HasPrefix(mask.String(), "$") {
+		ext, err := ParseExtBan(mask.String())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, mask)
+		}
+		return &UserMask{mask: Name(ext.String()), ext: ext, setAt: time.Now()}, nil
+	}
+
+	mask = ExpandUserHost(mask)
+	return &UserMask{
+		mask:    mask,
+		matcher: ircmatch.MakeMatch(mask.ToLower().String()),
+		setAt:   time.Now(),
+	}, nil
+}
+
+// Setter is who set the mask, as the list replies show it.
+func (mask *UserMask) Setter() Name {
+	if mask.setter == "" {
+		return "*"
+	}
+	return mask.setter
+}
+
+// listEntry is the mask as the list replies show it: the mask, who set it
+// and when, and its reason if it has one.
+func (mask *UserMask) listEntry() string {
+	entry := fmt.Sprintf("%s %s %d", mask.mask, mask.Setter(), mask.setAt.Unix())
+	if mask.reason != "" {
+		entry += " :" + mask.reason.String()
+	}
+	return entry
+}
+
+// Expired reports whether the mask has run out at now.
+func (mask *UserMask) Expired(now time.Time) bool {
+	return !mask.expires.IsZero() && !now.Before(mask.expires)
+}
+
+// Key is what the mask is stored under, so that masks differing only in
+// case are the same.
+func (mask *UserMask) Key() Name {
+	return mask.mask.ToLower()
+}
+
+// Match reports whether the mask matches client, whose userhosts are
+// given in lower case.
+func (mask *UserMask) Match(client *Client, userhosts []Name, followChannels bool) bool {
+	if mask.ext != nil {
+		return mask.ext.Match(client, followChannels)
+	}
+	for _, userhost := range userhosts {
+		if mask.matcher.Match(userhost.String()) {
+			return true
+		}
+	}
+	return false
+}
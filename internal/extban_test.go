@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExtBan(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, mask := range []string{"$a", "$a:alice", "$~a", "$r:*bot*", "$j:#test", "$z", "$z:ab"} {
+		ext, err := ParseExtBan(mask)
+		if assert.NoError(err, mask) {
+			assert.Equal(mask, ext.String())
+		}
+	}
+	ext, err := ParseExtBan("$z:AB:CD")
+	assert.NoError(err)
+	assert.Equal("$z:abcd", ext.String())
+
+	for _, mask := range []string{"$", "$x", "$r", "$j:test", "$aalice", "$a:"} {
+		_, err := ParseExtBan(mask)
+		assert.Error(err, mask)
+	}
+}
+
+func TestExtBans(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.Account = map[string]*AccountConfig{
+			"carol": {PassConfig: PassConfig{Password: testPassword(t, "secret")}},
+		}
+	})
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	carol := server.Connect()
+	carol.Send("CAP REQ :sasl")
+	carol.Send("AUTHENTICATE PLAIN")
+	carol.Send("AUTHENTICATE %s", saslPlain("carol", "secret"))
+	carol.Expect(` 903 `)
+	carol.Send("NICK carol")
+	carol.Send("USER carol 0 * :Carol the bot")
+	carol.Send("CAP END")
+	carol.Expect(` 001 carol `)
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+
+	alice.Send("MODE #test +b $x:foo")
+	alice.Expect(`^:irc.test.net 696 alice #test b \$x:foo :`)
+	alice.Send("MODE #test +b $a:CAROL")
+	alice.Expect(`^:alice!\S+ MODE #test \+b \$a:CAROL$`)
+	carol.Send("JOIN #test")
+	carol.Expect(`^:irc.test.net 474 carol #test `)
+
+	// masks differing in case are the same
+	alice.Send("MODE #test -b $a:carol")
+	alice.Expect(`^:alice!\S+ MODE #test -b \$a:carol$`)
+	alice.Send("MODE #test +b $r:*bot*")
+	alice.Expect(`^:alice!\S+ MODE #test \+b \$r:\*bot\*$`)
+	carol.Send("JOIN #test")
+	carol.Expect(`^:irc.test.net 474 carol #test `)
+	alice.Send("MODE #test +e $a")
+	alice.Expect(`^:alice!\S+ MODE #test \+e \$a$`)
+	carol.Send("JOIN #test")
+	alice.Expect(`^:carol!\S+ JOIN #test$`)
+
+	// bans in another channel
+	alice.Send("JOIN #other")
+	alice.Expect(`^:alice!\S+ JOIN #other$`)
+	alice.Send("MODE #test +b bob")
+	alice.Expect(`^:alice!\S+ MODE #test \+b bob!\*@\*$`)
+	alice.Send("MODE #other +b $j:#test")
+	alice.Expect(`^:alice!\S+ MODE #other \+b \$j:#test$`)
+	bob.Send("JOIN #other")
+	bob.Expect(`^:irc.test.net 474 bob #other `)
+
+	// only logged in clients may talk
+	alice.Send("MODE #other -b $j:#test")
+	alice.Expect(`^:alice!\S+ MODE #other -b \$j:#test$`)
+	alice.Send("MODE #other +q $~a")
+	alice.Expect(`^:alice!\S+ MODE #other \+q \$~a$`)
+	bob.Send("JOIN #other")
+	alice.Expect(`^:bob!\S+ JOIN #other$`)
+	bob.Send("PRIVMSG #other :hello")
+	bob.Expect(`^:irc.test.net 404 bob #other `)
+	carol.Send("JOIN #other")
+	alice.Expect(`^:carol!\S+ JOIN #other$`)
+	carol.Send("PRIVMSG #other :hello")
+	alice.Expect(`^:carol!\S+ PRIVMSG #other :hello$`)
+}
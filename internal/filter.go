@@ -0,0 +1,369 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/yaml.v2"
+)
+
+// FilterScope is a set of places a filter looks at text.
+type FilterScope uint
+
+const (
+	FilterChannel FilterScope = 1 << iota // channel messages and topics
+	FilterPrivate                         // private messages
+	FilterPart                            // part messages
+	FilterQuit                            // quit messages
+	FilterNick                            // nicknames
+)
+
+// filterScopeNames are the names of the scopes, in bit order.
+var filterScopeNames = []string{"channel", "private", "part", "quit", "nick"}
+
+func ParseFilterScopes(names []string) (FilterScope, error) {
+	var scopes FilterScope
+	for _, name := range names {
+		found := false
+		for bit, scopeName := range filterScopeNames {
+			if strings.EqualFold(name, scopeName) {
+				scopes |= 1 << bit
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown filter scope: %s", name)
+		}
+	}
+	if scopes == 0 {
+		return 0, errors.New("filter has no scope")
+	}
+	return scopes, nil
+}
+
+func (scopes FilterScope) String() string {
+	names := make([]string, 0, len(filterScopeNames))
+	for bit, name := range filterScopeNames {
+		if scopes&(1<<bit) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// FilterAction is what happens to a client whose text matches a filter.
+type FilterAction string
+
+const (
+	FilterBlock  FilterAction = "block"  // drop the text
+	FilterWarn   FilterAction = "warn"   // let it through, warning the client
+	FilterKill   FilterAction = "kill"   // disconnect the client
+	FilterBan    FilterAction = "ban"    // disconnect and ban the client's host for a while
+	FilterReport FilterAction = "report" // let it through, telling the operators
+)
+
+var FilterActions = []FilterAction{
+	FilterBlock, FilterWarn, FilterKill, FilterBan, FilterReport,
+}
+
+// FilterConfig is a filter as FILTER ADD gives it and the filter file
+// stores it.
+type FilterConfig struct {
+	Pattern  string
+	Regexp   bool `yaml:",omitempty"`
+	Scopes   []string
+	Action   string
+	Duration time.Duration `yaml:",omitempty"` // how long a ban lasts
+	Reason   string        `yaml:",omitempty"`
+	Setter   string        `yaml:",omitempty"`
+}
+
+// Filter matches text that operators don't want on the network: a regular
+// expression, or text to look for, in either case ignoring case.
+type Filter struct {
+	config FilterConfig
+	regexp *regexp.Regexp // nil for plain text
+	lower  string         // the text to look for, in lower case
+	scopes FilterScope
+	action FilterAction
+}
+
+func NewFilter(config FilterConfig) (*Filter, error) {
+	if config.Pattern == "" {
+		return nil, errors.New("filter has no pattern")
+	}
+	filter := &Filter{config: config}
+
+	if config.Regexp {
+		compiled, err := regexp.Compile("(?i)" + config.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.regexp = compiled
+	} else {
+		filter.lower = strings.ToLower(config.Pattern)
+	}
+
+	scopes, err := ParseFilterScopes(config.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	filter.scopes = scopes
+	filter.config.Scopes = strings.Split(scopes.String(), ",")
+
+	for _, action := range FilterActions {
+		if strings.EqualFold(config.Action, string(action)) {
+			filter.action = action
+		}
+	}
+	switch {
+	case filter.action == "":
+		return nil, fmt.Errorf("unknown filter action: %s", config.Action)
+	case filter.action == FilterBan && config.Duration <= 0:
+		return nil, errors.New("ban filter needs a duration")
+	case filter.action != FilterBan && config.Duration != 0:
+		return nil, errors.New("only a ban filter has a duration")
+	}
+	filter.config.Action = string(filter.action)
+	return filter, nil
+}
+
+// Match reports whether text, with its colors taken out, matches.
+func (filter *Filter) Match(text Text) bool {
+	str := StripColorCodes(text).String()
+	if filter.regexp != nil {
+		return filter.regexp.MatchString(str)
+	}
+	return strings.Contains(strings.ToLower(str), filter.lower)
+}
+
+// Reason is what the client is told its text matched.
+func (filter *Filter) Reason() Text {
+	if filter.config.Reason == "" {
+		return "Matched a content filter"
+	}
+	return NewText(filter.config.Reason)
+}
+
+func (filter *Filter) String() string {
+	kind := "text"
+	if filter.config.Regexp {
+		kind = "regexp"
+	}
+	action := filter.config.Action
+	if filter.action == FilterBan {
+		action += ":" + filter.config.Duration.String()
+	}
+	return fmt.Sprintf("%q %s %s %s", filter.config.Pattern, kind, filter.scopes, action)
+}
+
+// FilterList holds the filters, in the order they were added, and saves
+// them to the filter file, if there is one.
+type FilterList struct {
+	file    string
+	filters []*Filter
+}
+
+// LoadFilterList reads the filters saved in file. A file that doesn't
+// exist yet holds no filters.
+func LoadFilterList(file string) (*FilterList, error) {
+	list := &FilterList{file: file}
+	if file == "" {
+		return list, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return list, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var configs []FilterConfig
+	if err := yaml.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+	for _, config := range configs {
+		filter, err := NewFilter(config)
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", config.Pattern, err)
+		}
+		list.Add(filter)
+	}
+	return list, nil
+}
+
+// Save writes the filters to the filter file, replacing it in one go so
+// that a failed write never leaves half a list behind.
+func (list *FilterList) Save() error {
+	if list.file == "" {
+		return nil
+	}
+
+	configs := make([]FilterConfig, len(list.filters))
+	for index, filter := range list.filters {
+		configs[index] = filter.config
+	}
+	data, err := yaml.Marshal(configs)
+	if err != nil {
+		return err
+	}
+
+	temp := list.file + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, list.file)
+}
+
+// Add adds filter, replacing any filter with the same pattern.
+func (list *FilterList) Add(filter *Filter) {
+	for index, other := range list.filters {
+		if other.config.Pattern == filter.config.Pattern {
+			list.filters[index] = filter
+			return
+		}
+	}
+	list.filters = append(list.filters, filter)
+}
+
+func (list *FilterList) Remove(pattern string) bool {
+	for index, filter := range list.filters {
+		if filter.config.Pattern == pattern {
+			list.filters = append(list.filters[:index], list.filters[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the first filter for scope that text matches, or nil.
+func (list *FilterList) Match(scope FilterScope, text Text) *Filter {
+	for _, filter := range list.filters {
+		if filter.scopes&scope != 0 && filter.Match(text) {
+			return filter
+		}
+	}
+	return nil
+}
+
+// Filter checks text the client sent to target against the filters for
+// scope, and does what the first filter it matches says. It reports
+// whether the text may go through; if not, the client may be gone.
+// Operators are never filtered.
+func (c *Client) Filter(scope FilterScope, target Name, text Text) bool {
+	server := c.server
+	if c.modes.Has(Operator) {
+		return true
+	}
+	filter := server.filters.Match(scope, text)
+	if filter == nil {
+		return true
+	}
+
+	if filter.action != FilterWarn {
+		where := scope.String()
+		if target != "" {
+			where += " " + target.String()
+		}
+		report := fmt.Sprintf("Filter %q (%s) matched %s from %s",
+			filter.config.Pattern, filter.action, where, c.UserHost(true))
+		// private messages stay private
+		if scope != FilterPrivate {
+			report += ": " + text.String()
+		}
+		server.OperNoticef(PrivFilter, "%s", report)
+	}
+
+	switch filter.action {
+	case FilterWarn:
+		c.Reply(RplNotice(server, c, "Warning: "+filter.Reason()))
+	case FilterReport:
+	case FilterBlock:
+		if scope == FilterNick {
+			c.ErrErroneusNickname(NewName(text.String()))
+		} else {
+			c.Reply(RplNotice(server, c, "Blocked: "+filter.Reason()))
+		}
+		return false
+	case FilterKill:
+		c.Quit("Killed by a filter: " + filter.Reason())
+		return false
+	case FilterBan:
+		server.ban(c, filter.config.Duration, filter.Reason())
+		c.Quit("Banned by a filter: " + filter.Reason())
+		return false
+	}
+	return true
+}
+
+// ban keeps the client's host off the server for duration. Bans that have
+// run out are only removed when another is set.
+func (server *Server) ban(client *Client, duration time.Duration, reason Text) {
+	now := time.Now()
+	server.bans.Range(func(ban *UserMask) bool {
+		if ban.Expired(now) {
+			server.bans.remove(ban)
+		}
+		return true
+	})
+
+	usermask, err := NewUserMask(Name("*!*@" + client.hostname.String()))
+	if err != nil {
+		return
+	}
+	usermask.setter = server.name
+	usermask.expires = usermask.setAt.Add(duration)
+	usermask.reason = reason
+	server.bans.remove(server.bans.masks[usermask.Key()])
+	server.bans.Add(usermask)
+}
+
+func (msg *FilterCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.HasPrivilege(PrivFilter) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	notice := func(format string, args ...interface{}) {
+		client.Reply(RplNotice(server, client, NewText(fmt.Sprintf(format, args...))))
+	}
+
+	switch msg.subCommand {
+	case "ADD":
+		msg.filter.Setter = client.Nick().String()
+		filter, err := NewFilter(msg.filter)
+		if err != nil {
+			client.StandardReply(NewFail(FILTER, "INVALID_FILTER", err.Error()), msg.filter.Pattern)
+			return
+		}
+		server.filters.Add(filter)
+		notice("Added filter %s", filter)
+
+	case "DEL":
+		if !server.filters.Remove(msg.filter.Pattern) {
+			client.StandardReply(FailFilterNoSuch, msg.filter.Pattern)
+			return
+		}
+		notice("Removed filter %q", msg.filter.Pattern)
+
+	case "LIST":
+		for _, filter := range server.filters.filters {
+			notice("%s set by %s: %s", filter, filter.config.Setter, filter.Reason())
+		}
+		notice("End of FILTER list")
+		return
+	}
+
+	if err := server.filters.Save(); err != nil {
+		log.Errorf("filter file save error, %s", err)
+		notice("The filters could not be saved: %s", err)
+	}
+}
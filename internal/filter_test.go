@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	server := newTestServer(t, withOper(t))
+	oper := server.Register("oper")
+	oper.Oper()
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	alice.Send("JOIN #test")
+	alice.Expect(` 366 alice #test `)
+	bob.Send("JOIN #test")
+	bob.Expect(` 366 bob #test `)
+	bob.Send("MODE bob +w")
+	bob.Expect(` MODE bob :?\+w$`)
+
+	alice.Send("FILTER LIST")
+	alice.Expect(`^:irc.test.net 481 alice `)
+
+	oper.Send("FILTER ADD text channel,private block example.com :Phishing")
+	oper.Expect(`^:irc.test.net NOTICE oper :Added filter "example.com" text channel,private block$`)
+	oper.Send("FILTER ADD regexp channel block ( :Broken")
+	oper.Expect(`^:irc.test.net NOTICE oper :error parsing regexp`)
+	oper.Send("CAP REQ :standard-replies")
+	oper.Expect(` ACK :standard-replies$`)
+	oper.Send("FILTER ADD text everywhere block spam")
+	oper.Expect(`^:irc.test.net FAIL FILTER INVALID_FILTER spam :unknown filter scope: everywhere$`)
+	oper.Send("FILTER ADD text nick ban spam")
+	oper.Expect(`^:irc.test.net FAIL FILTER INVALID_FILTER spam :ban filter needs a duration$`)
+
+	alice.Send("PRIVMSG #test :log in at EXAMPLE.com")
+	alice.Expect(`^:irc.test.net NOTICE alice :Blocked: Phishing$`)
+	oper.Expect(`^:irc.test.net NOTICE oper :Filter "example.com" \(block\) matched channel #test from alice!alice@[0-9A-F]+\.ip: log in at EXAMPLE.com$`)
+	// colors don't hide the text
+	alice.Send("NOTICE bob :\x0304example\x03.com")
+	alice.Expect(`^:irc.test.net NOTICE alice :Blocked: Phishing$`)
+	oper.Expect(`^:irc.test.net NOTICE oper :Filter "example.com" \(block\) matched private bob from alice!alice@\S+$`)
+	alice.Send("TOPIC #test :example.com")
+	alice.Expect(`^:irc.test.net NOTICE alice :Blocked: Phishing$`)
+	// +w doesn't show filter reports
+	bob.ExpectNone(`example`)
+
+	// operators aren't filtered
+	oper.Send("PRIVMSG bob :example.com")
+	bob.Expect(`^:oper!\S+ PRIVMSG bob :example.com$`)
+
+	oper.Send("FILTER LIST")
+	oper.Expect(`^:irc.test.net NOTICE oper :"example.com" text channel,private block set by oper: Phishing$`)
+	oper.Expect(`^:irc.test.net NOTICE oper :End of FILTER list$`)
+
+	oper.Send("FILTER DEL example.com")
+	oper.Expect(`^:irc.test.net NOTICE oper :Removed filter "example.com"$`)
+	oper.Send("FILTER DEL example.com")
+	oper.Expect(`^:irc.test.net FAIL FILTER NO_SUCH_FILTER example.com :No such filter$`)
+	alice.Send("PRIVMSG #test :example.com")
+	bob.Expect(`^:alice!\S+ PRIVMSG #test :example.com$`)
+}
+
+func TestFilterActions(t *testing.T) {
+	server := newTestServer(t, withOper(t))
+	oper := server.Register("oper")
+	oper.Oper()
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	alice.Send("JOIN #test")
+	alice.Expect(` 366 alice #test `)
+	bob.Send("JOIN #test")
+	bob.Expect(` 366 bob #test `)
+
+	oper.Send("FILTER ADD text private warn casino :Please don't advertise")
+	oper.Expect(` NOTICE oper :Added filter `)
+	alice.Send("PRIVMSG bob :casino night")
+	alice.Expect(`^:irc.test.net NOTICE alice :Warning: Please don't advertise$`)
+	bob.Expect(`^:alice!\S+ PRIVMSG bob :casino night$`)
+	oper.ExpectNone(`casino`)
+
+	oper.Send("FILTER ADD regexp part,quit report ^bye+$")
+	oper.Expect(` NOTICE oper :Added filter `)
+	alice.Send("PART #test :byeee")
+	bob.Expect(`^:alice!\S+ PART #test :byeee$`)
+	oper.Expect(`^:irc.test.net NOTICE oper :Filter "\^bye\+\$" \(report\) matched part from alice!\S+: byeee$`)
+
+	oper.Send("FILTER ADD text part block rude")
+	oper.Expect(` NOTICE oper :Added filter `)
+	bob.Send("PART #test :rude words")
+	bob.Expect(`^:irc.test.net NOTICE bob :Blocked: `)
+	bob.Expect(`^:bob!\S+ PART #test :bob$`)
+
+	oper.Send("FILTER ADD text nick kill spambot :Spam bot")
+	oper.Expect(` NOTICE oper :Added filter `)
+	alice.Send("NICK spambot42")
+	alice.Expect(`^ERROR :Killed by a filter: Spam bot$`)
+	alice.ExpectClosed()
+
+	carol := server.Connect()
+	carol.Send("NICK SpamBot")
+	carol.Expect(`^ERROR :Killed by a filter: Spam bot$`)
+	carol.ExpectClosed()
+
+	oper.Send("FILTER ADD text quit ban:1h buy :Advertising")
+	oper.Expect(` NOTICE oper :Added filter "buy" text quit ban:1h0m0s$`)
+	bob.Send("QUIT :buy now")
+	bob.Expect(`^ERROR :Banned by a filter: Advertising$`)
+	bob.ExpectClosed()
+	oper.Send("STATS k")
+	oper.Expect(`^:irc.test.net 216 oper K \S+ \* \* :Advertising$`)
+	oper.Expect(` 219 oper k `)
+
+	dave := server.Connect()
+	dave.Send("NICK dave")
+	dave.Send("USER dave 0 * :Dave")
+	dave.Expect(`^:irc.test.net 465 dave :You are banned from this server \(Advertising\)$`)
+	dave.ExpectClosed()
+}
+
+func TestFilterFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "ircd.yml")
+	filterFile := filepath.Join(dir, "filters.yml")
+	err := os.WriteFile(filename, []byte(`
+network:
+  name: TestNet
+server:
+  name: irc.test.net
+  listen: ["127.0.0.1:0"]
+  filterfile: `+filterFile+`
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t, withOper(t), func(config *Config) {
+		config.filename = filename
+		config.Server.FilterFile = filterFile
+	})
+	oper := server.Register("oper")
+	oper.Oper()
+	alice := server.Register("alice")
+
+	oper.Send("FILTER ADD text channel,private block:10m spam")
+	oper.Expect(`^:irc.test.net NOTICE oper :only a ban filter has a duration$`)
+	oper.Send("FILTER ADD text channel,private block spam :No spam")
+	oper.Expect(` NOTICE oper :Added filter `)
+
+	list, err := LoadFilterList(filterFile)
+	if assert.NoError(err) && assert.Len(list.filters, 1) {
+		config := list.filters[0].config
+		assert.Equal("spam", config.Pattern)
+		assert.Equal([]string{"channel", "private"}, config.Scopes)
+		assert.Equal("block", config.Action)
+		assert.Equal("No spam", config.Reason)
+		assert.Equal("oper", config.Setter)
+	}
+
+	err = os.WriteFile(filterFile, []byte(`
+- pattern: bad\.example
+  regexp: true
+  scopes: [private]
+  action: ban
+  duration: 1h
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oper.Send("REHASH")
+	oper.Expect(` 382 oper `)
+
+	alice.Send("PRIVMSG oper :spam")
+	oper.Expect(`^:alice!\S+ PRIVMSG oper :spam$`)
+	alice.Send("PRIVMSG oper :bad.example")
+	alice.Expect(`^ERROR :Banned by a filter: Matched a content filter$`)
+	alice.ExpectClosed()
+
+	// a broken filter file doesn't keep the rest of the config out
+	motdFile := filepath.Join(dir, "ircd.motd")
+	err = os.WriteFile(motdFile, []byte("Welcome\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filename, []byte(`
+network:
+  name: TestNet
+server:
+  name: irc.test.net
+  listen: ["127.0.0.1:0"]
+  filterfile: `+filterFile+`
+  motd: `+motdFile+`
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filterFile, []byte(`
+- pattern: spam
+  scopes: [private]
+  action: explode
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oper.Send("REHASH")
+	oper.Expect(`^:irc.test.net NOTICE oper :ERROR: Rehashing config failed \(filter "spam": unknown filter action: explode\)$`)
+	oper.Send("MOTD")
+	oper.Expect(`^:irc.test.net 372 oper :- Welcome$`)
+}
+
+func TestFilterRehashWithoutFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ircd.yml")
+	err := os.WriteFile(filename, []byte(`
+network:
+  name: TestNet
+server:
+  name: irc.test.net
+  listen: ["127.0.0.1:0"]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTestServer(t, withOper(t), func(config *Config) {
+		config.filename = filename
+	})
+	oper := server.Register("oper")
+	oper.Oper()
+	alice := server.Register("alice")
+
+	oper.Send("FILTER ADD text private block spam :No spam")
+	oper.Expect(` NOTICE oper :Added filter `)
+	oper.Send("REHASH")
+	oper.Expect(` 382 oper `)
+
+	alice.Send("PRIVMSG oper :spam")
+	alice.Expect(`^:irc.test.net NOTICE alice :Blocked: No spam$`)
+}
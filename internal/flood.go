@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"time"
+)
+
+// FloodControl limits how fast a client may send messages. Each message
+// fills the bucket by one, and it drains at the configured rate.
+type FloodControl struct {
+	limit  float64
+	rate   float64 // messages drained per second
+	level  float64
+	filled time.Time
+}
+
+func NewFloodControl(config FloodConfig) *FloodControl {
+	if config.Messages <= 0 || config.Period <= 0 {
+		return nil
+	}
+	return &FloodControl{
+		limit: float64(config.Messages),
+		rate:  float64(config.Messages) / config.Period.Seconds(),
+	}
+}
+
+// Allow counts a message sent at now and reports whether the client is
+// still within its limit. A nil FloodControl allows everything.
+func (flood *FloodControl) Allow(now time.Time) bool {
+	if flood == nil {
+		return true
+	}
+
+	if !flood.filled.IsZero() {
+		flood.level -= now.Sub(flood.filled).Seconds() * flood.rate
+		if flood.level < 0 {
+			flood.level = 0
+		}
+	}
+	flood.filled = now
+	flood.level += 1
+	return flood.level <= flood.limit
+}
+
+// countsAsMessage reports whether cmd is a message for flood control. The
+// lines of a multiline batch are counted once, when the batch ends.
+func countsAsMessage(cmd Command) bool {
+	switch cmd := cmd.(type) {
+	case *PrivMsgCommand, *NoticeCommand, *TagMsgCommand:
+		_, batched := cmd.Tags()["batch"]
+		return !batched
+	case *BatchCommand:
+		return !cmd.start
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/common/log"
+)
+
+const (
+	// MaxTagsLen is the longest tag section, with its '@' and trailing
+	// space, the server sends.
+	MaxTagsLen = 8191
+	// MaxClientTagsLen is the longest tag section a client may send.
+	MaxClientTagsLen = 4096
+	// MaxInputLen is the longest line read from a client, CRLF included.
+	MaxInputLen = MaxClientTagsLen + MAX_REPLY_LEN + len(CRLF)
+)
+
+// frameLine returns the line to send for reply with the tag prefix tags.
+// A reply too long for a line is cut short, and tags that don't fit are
+// dropped, rather than sending something clients would discard.
+func frameLine(tags string, reply string) string {
+	if len(reply) > MAX_REPLY_LEN {
+		log.Debugf("truncating reply of %d bytes: %s", len(reply), reply)
+		reply = reply[:cutText(reply, MAX_REPLY_LEN)]
+	}
+	if len(tags) > MaxTagsLen {
+		log.Debugf("dropping tags of %d bytes: %s", len(tags), tags)
+		tags = ""
+	}
+	return tags + reply
+}
+
+// checkInputLen checks that a line read from a client, without its CRLF,
+// is within the limits for its tags and the rest of the message.
+func checkInputLen(line string) error {
+	message := line
+	if strings.HasPrefix(line, "@") {
+		tags, rest, _ := strings.Cut(line, " ")
+		if len(tags)+len(" ") > MaxClientTagsLen {
+			return ErrInputTooLong
+		}
+		message = strings.TrimLeft(rest, " ")
+	}
+	if len(message) > MAX_REPLY_LEN {
+		return ErrInputTooLong
+	}
+	return nil
+}
+
+// SplitText splits text into parts of at most maxLen bytes. It splits at a
+// space when there is one late enough, and never inside a UTF-8 sequence
+// or a formatting code.
+func SplitText(text Text, maxLen int) []Text {
+	if maxLen <= 0 {
+		return []Text{text}
+	}
+
+	var parts []Text
+	for len(text) > maxLen {
+		cut := cutText(string(text), maxLen)
+		if space := strings.LastIndexByte(string(text[:cut]), ' '); space >= cut/2 {
+			cut = space + 1
+		}
+		parts = append(parts, text[:cut])
+		text = text[cut:]
+	}
+	return append(parts, text)
+}
+
+// cutText returns the largest index of at most maxLen at which text can be
+// cut without splitting a UTF-8 sequence or a formatting code.
+func cutText(text string, maxLen int) int {
+	index := 0
+	for index < len(text) {
+		next := index + formattingLen(text[index:])
+		if next > maxLen {
+			break
+		}
+		index = next
+	}
+	if index == 0 {
+		// a single code longer than a line: split it after all
+		for index = maxLen; index > 0 && !utf8.RuneStart(text[index]); index-- {
+		}
+	}
+	return index
+}
+
+// formattingLen returns the length of the formatting code or rune that
+// text starts with.
+func formattingLen(text string) int {
+	switch text[0] {
+	case '\x03':
+		return 1 + colorLen(text[1:], isDigit, 2)
+	case '\x04':
+		return 1 + colorLen(text[1:], isHexDigit, 6)
+	}
+	_, size := utf8.DecodeRuneInString(text)
+	return size
+}
+
+// colorLen returns the length of the foreground[,background] arguments of
+// a color code, each up to maxLen characters accepted by valid.
+func colorLen(text string, valid func(byte) bool, maxLen int) int {
+	digits := func(text string) int {
+		n := 0
+		for n < len(text) && n < maxLen && valid(text[n]) {
+			n++
+		}
+		return n
+	}
+
+	n := digits(text)
+	if n == 0 {
+		return 0
+	}
+	if n < len(text) && text[n] == ',' {
+		if background := digits(text[n+1:]); background > 0 {
+			n += 1 + background
+		}
+	}
+	return n
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// HasColors reports whether text contains a color code.
+func HasColors(text Text) bool {
+	return strings.ContainsAny(string(text), "\x03\x04")
+}
+
+// StripColorCodes removes the color codes, with their arguments, from text
+// and leaves other formatting alone.
+func StripColorCodes(text Text) Text {
+	var stripped strings.Builder
+	str := string(text)
+	for len(str) > 0 {
+		n := formattingLen(str)
+		if str[0] != '\x03' && str[0] != '\x04' {
+			stripped.WriteString(str[:n])
+		}
+		str = str[n:]
+	}
+	return Text(stripped.String())
+}
+
+// IsCTCP reports whether message is a CTCP request or reply other than an
+// ACTION.
+func IsCTCP(message Text) bool {
+	return strings.HasPrefix(string(message), "\x01") &&
+		!strings.HasPrefix(string(message), "\x01ACTION ")
+}
+
+// messageReplies returns the PRIVMSG or NOTICE lines carrying message from
+// source to target, split so that each fits in a line.
+func messageReplies(code StringCode, source Identifiable, target Identifiable, message Text) []string {
+	reply := RplPrivMsg
+	if code == NOTICE {
+		reply = RplNotice
+	}
+
+	maxLen := MAX_REPLY_LEN - len(reply(source, target, ""))
+	texts := SplitText(message, maxLen)
+	replies := make([]string, len(texts))
+	for index, text := range texts {
+		replies[index] = reply(source, target, text)
+	}
+	return replies
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitText(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]Text{"short"}, SplitText("short", 10))
+	assert.Equal([]Text{"hello ", "world"}, SplitText("hello world", 8))
+	assert.Equal([]Text{"abcdefgh", "ij"}, SplitText("abcdefghij", 8))
+
+	// runes are never split
+	parts := SplitText(Text(strings.Repeat("é", 10)), 5)
+	for _, part := range parts {
+		assert.True(utf8.ValidString(string(part)), part)
+		assert.True(len(part) <= 5, part)
+	}
+	assert.Equal(Text(strings.Repeat("é", 10)), Text(strings.Join(textStrings(parts), "")))
+
+	// nor are color codes
+	assert.Equal([]Text{"abc", "\x0312,04x"}, SplitText("abc\x0312,04x", 7))
+	assert.Equal([]Text{"ab", "\x04ff00ffx"}, SplitText("ab\x04ff00ffx", 8))
+}
+
+func textStrings(texts []Text) []string {
+	strs := make([]string, len(texts))
+	for index, text := range texts {
+		strs[index] = string(text)
+	}
+	return strs
+}
+
+func TestFrameLine(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("@a=b :x PRIVMSG y :z", frameLine("@a=b ", ":x PRIVMSG y :z"))
+
+	long := ":x PRIVMSG y :" + strings.Repeat("é", 300)
+	framed := frameLine("", long)
+	assert.True(len(framed) <= MAX_REPLY_LEN)
+	assert.True(utf8.ValidString(framed))
+	assert.True(strings.HasPrefix(long, framed))
+
+	tags := "@a=" + strings.Repeat("x", MaxTagsLen) + " "
+	assert.Equal(":x PING y", frameLine(tags, ":x PING y"))
+}
+
+func TestCheckInputLen(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkInputLen("PRIVMSG x :" + strings.Repeat("a", 490)))
+	assert.Equal(ErrInputTooLong, checkInputLen("PRIVMSG x :"+strings.Repeat("a", 500)))
+
+	tags := "@+a=" + strings.Repeat("x", 4000)
+	assert.NoError(checkInputLen(tags + " PRIVMSG x :y"))
+	assert.Equal(ErrInputTooLong, checkInputLen(tags+strings.Repeat("x", 100)+" PRIVMSG x :y"))
+}
+
+func TestInputTooLong(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+
+	alice.Send("PRIVMSG alice :%s", strings.Repeat("a", 600))
+	alice.Expect(`^:irc.test.net 417 alice :`)
+
+	// longer than the read buffer
+	alice.Send("PRIVMSG alice :%s", strings.Repeat("a", 2*MaxInputLen))
+	alice.Expect(`^:irc.test.net 417 alice :`)
+
+	alice.Send("PING x")
+	alice.Expect(`PONG \S+ :x$`)
+}
+
+func TestLongMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	// fits in alice's line, but not once the server adds alice's prefix
+	message := strings.Repeat("ü", 245)
+	alice.Send("PRIVMSG bob :%s", message)
+	first := bob.Expect(`^(:alice!\S+ PRIVMSG bob :)(\S+)$`)
+	second := bob.Expect(`^:alice!\S+ PRIVMSG bob :(\S+)$`)
+	assert.True(len(first[0]) <= MAX_REPLY_LEN)
+	assert.Equal(message, first[2]+second[1])
+}
+
+func TestLongMessageMsgids(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.RegisterWithCaps("bob", "message-tags")
+
+	// each line of a split message has its own msgid
+	alice.Send("PRIVMSG bob :%s", strings.Repeat("ü", 245))
+	first := bob.Expect(`^@msgid=(\S+) :alice!\S+ PRIVMSG bob :`)
+	bob.Expect(`^@msgid=%s-1 :alice!\S+ PRIVMSG bob :`, regexp.QuoteMeta(first[1]))
+}
+
+func TestStripColorCodes(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(Text("red and \x02bold"), StripColorCodes("\x0304,12red\x03 and \x02bold"))
+	assert.Equal(Text(",2 x"), StripColorCodes("\x03\x03,2 x"))
+	assert.True(HasColors("\x04ff0000hex"))
+	assert.False(HasColors("\x02bold"))
+
+	assert.True(IsCTCP("\x01VERSION\x01"))
+	assert.False(IsCTCP("\x01ACTION waves\x01"))
+}
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// how long Expect waits for a matching line before failing the test
+const expectTimeout = 5 * time.Second
+
+// testServer is a Server without listeners; clients are connected to it
+// over in-memory pipes.
+type testServer struct {
+	*Server
+	t *testing.T
+}
+
+// newTestServer starts a server for the duration of the test. configure
+// functions may adjust the config before the server is created.
+func newTestServer(t *testing.T, configure ...func(*Config)) *testServer {
+	config := &Config{}
+	config.Network.Name = "TestNet"
+	config.Server.Name = "irc.test.net"
+	config.Cloaking.Secrets = []string{"test"}
+	for _, f := range configure {
+		f(config)
+	}
+
+	server := NewServer(config)
+	go server.Run()
+	t.Cleanup(server.Stop)
+
+	return &testServer{Server: server, t: t}
+}
+
+// withOper configures an operator "oper" with the password "secret".
+func withOper(t *testing.T) func(*Config) {
+	return func(config *Config) {
+		if config.Operator == nil {
+			config.Operator = make(map[string]*OperConfig)
+		}
+		config.Operator["oper"] = &OperConfig{PassConfig: PassConfig{Password: testPassword(t, "secret")}}
+	}
+}
+
+// Connect opens a new, unregistered connection to the server.
+func (s *testServer) Connect() *testClient {
+	conn, remote := net.Pipe()
+	s.accept(remote, TransportTCP)
+
+	c := &testClient{
+		t:     s.t,
+		conn:  conn,
+		lines: make(chan string, 1024),
+	}
+	go c.readloop()
+	s.t.Cleanup(func() { conn.Close() })
+
+	return c
+}
+
+// Register connects a client and completes registration as nick.
+func (s *testServer) Register(nick string) *testClient {
+	c := s.Connect()
+	c.Send("NICK %s", nick)
+	c.Send("USER %s 0 * :%s", nick, nick)
+	c.Expect(`^:\S+ 001 %s `, nick)
+	c.Expect(`^:\S+ (376|422) %s `, nick)
+	c.nick = nick
+	return c
+}
+
+// RegisterWithCaps is Register, requesting capabilities first.
+func (s *testServer) RegisterWithCaps(nick string, caps ...string) *testClient {
+	c := s.Connect()
+	c.Send("CAP LS")
+	c.Send("CAP REQ :%s", strings.Join(caps, " "))
+	c.Expect(`^(@\S+ )?:\S+ CAP \S+ ACK `)
+	c.Send("NICK %s", nick)
+	c.Send("USER %s 0 * :%s", nick, nick)
+	c.Send("CAP END")
+	c.Expect(`^(@\S+ )?:\S+ 001 %s `, nick)
+	c.Expect(`^(@\S+ )?:\S+ (376|422) %s `, nick)
+	c.nick = nick
+	return c
+}
+
+// testClient scripts one side of an IRC session.
+type testClient struct {
+	t     *testing.T
+	conn  net.Conn
+	nick  string
+	lines chan string
+}
+
+func (c *testClient) readloop() {
+	defer close(c.lines)
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		c.lines <- strings.TrimRight(scanner.Text(), "\r")
+	}
+}
+
+// Oper logs the client in as the operator set up by withOper.
+func (c *testClient) Oper() {
+	c.t.Helper()
+
+	c.Send("OPER oper secret")
+	c.Expect(` 381 %s `, c.nick)
+}
+
+// Send writes a line to the server.
+func (c *testClient) Send(format string, args ...interface{}) {
+	c.t.Helper()
+
+	c.conn.SetWriteDeadline(time.Now().Add(expectTimeout))
+	if _, err := fmt.Fprintf(c.conn, format+"\r\n", args...); err != nil {
+		c.t.Fatalf("%s: send %q: %s", c.nick, fmt.Sprintf(format, args...), err)
+	}
+}
+
+// Expect skips lines until one matches the regular expression built from
+// format and args, and returns its submatches. The test fails if no such
+// line arrives in time or the server hangs up.
+func (c *testClient) Expect(format string, args ...interface{}) []string {
+	c.t.Helper()
+
+	pattern := regexp.MustCompile(fmt.Sprintf(format, args...))
+	timeout := time.After(expectTimeout)
+	var skipped []string
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				c.t.Fatalf("%s: connection closed waiting for %s; got:\n%s",
+					c.nick, pattern, strings.Join(skipped, "\n"))
+			}
+			if match := pattern.FindStringSubmatch(line); match != nil {
+				return match
+			}
+			skipped = append(skipped, line)
+		case <-timeout:
+			c.t.Fatalf("%s: timed out waiting for %s; got:\n%s",
+				c.nick, pattern, strings.Join(skipped, "\n"))
+		}
+	}
+}
+
+// ExpectNone fails the test if a line matching format arrives before the
+// server has answered everything sent so far.
+func (c *testClient) ExpectNone(format string, args ...interface{}) {
+	c.t.Helper()
+
+	pattern := regexp.MustCompile(fmt.Sprintf(format, args...))
+	c.Send("PING sync")
+	timeout := time.After(expectTimeout)
+	for {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				c.t.Fatalf("%s: connection closed waiting for PONG", c.nick)
+			}
+			if strings.Contains(line, " PONG ") && strings.HasSuffix(line, ":sync") {
+				return
+			}
+			if pattern.MatchString(line) {
+				c.t.Fatalf("%s: unexpected %q", c.nick, line)
+			}
+		case <-timeout:
+			c.t.Fatalf("%s: timed out waiting for PONG", c.nick)
+		}
+	}
+}
+
+// ExpectClosed waits for the server to hang up.
+func (c *testClient) ExpectClosed() {
+	c.t.Helper()
+
+	timeout := time.After(expectTimeout)
+	for {
+		select {
+		case _, ok := <-c.lines:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			c.t.Fatalf("%s: timed out waiting for the connection to close", c.nick)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"sort"
+	"time"
+)
+
+// IsInvited reports whether the client has an invite to channel that
+// hasn't expired.
+func (c *Client) IsInvited(channel *Channel) bool {
+	expires, ok := c.invites[channel]
+	if ok && !time.Now().Before(expires) {
+		delete(c.invites, channel)
+		return false
+	}
+	return ok
+}
+
+// listInvites replies with the client's pending invites, dropping the ones
+// that expired or whose channel is gone.
+func (c *Client) listInvites() {
+	channels := make([]*Channel, 0, len(c.invites))
+	for channel := range c.invites {
+		if !c.IsInvited(channel) {
+			continue
+		}
+		if c.server.channels.Get(channel.name) != channel {
+			delete(c.invites, channel)
+			continue
+		}
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+	for _, channel := range channels {
+		c.RplInvitedList(channel)
+	}
+	c.RplEndOfInvitedList()
+}
+
+// KNOCK asks the operators of a channel the client can't join for an
+// invite. Both the client and the channel are rate limited.
+func (msg *KnockCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	channel := server.channels.Get(msg.channel)
+	if channel == nil || !CanSeeChannel(client, channel) {
+		client.ErrNoSuchChannel(msg.channel)
+		return
+	}
+	if channel.members.Has(client) {
+		client.ErrKnockOnChan(channel)
+		return
+	}
+	if !channel.flags.Has(InviteOnly) && channel.key == "" && !channel.IsFull() {
+		client.ErrChanOpen(channel)
+		return
+	}
+	if channel.lists[BanMask].Match(client) && !channel.lists[ExceptMask].Match(client) {
+		client.ErrBannedFromChan(channel, "")
+		return
+	}
+
+	now := time.Now()
+	if !client.knocks.Allow(now) || !channel.knocks.Allow(now) {
+		client.ErrTooManyKnock(channel)
+		return
+	}
+
+	channel.members.Range(func(member *Client, modes *ChannelModeSet) bool {
+		if modes.Has(ChannelOperator) {
+			member.RplKnock(channel, client, msg.message)
+		}
+		return true
+	})
+	client.RplKnockDelivered(channel)
+}
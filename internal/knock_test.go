@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKnock(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	carol := server.Register("carol")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("KNOCK #test")
+	bob.Expect(`^:irc.test.net 713 bob #test :Channel is open$`)
+	alice.Send("KNOCK #test")
+	alice.Expect(`^:irc.test.net 714 alice #test `)
+	bob.Send("KNOCK #nowhere")
+	bob.Expect(`^:irc.test.net 403 bob #nowhere `)
+
+	alice.Send("MODE #test +i")
+	alice.Expect(`^:alice!\S+ MODE #test \+i$`)
+	bob.Send("KNOCK #test :let me in")
+	alice.Expect(`^:irc.test.net 710 alice #test bob!bob@\S+ :has asked for an invite \(let me in\)$`)
+	bob.Expect(`^:irc.test.net 711 bob #test `)
+
+	// once a minute for each knocker and each channel
+	bob.Send("KNOCK #test")
+	bob.Expect(`^:irc.test.net 712 bob #test `)
+	carol.Send("KNOCK #test")
+	carol.Expect(`^:irc.test.net 712 carol #test `)
+	alice.ExpectNone(` 710 `)
+}
+
+func TestInviteOnce(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("MODE #test +i")
+	alice.Expect(`^:alice!\S+ MODE #test \+i$`)
+
+	alice.Send("INVITE bob #test")
+	bob.Expect(`^:alice!\S+ INVITE bob :#test$`)
+	bob.Send("INVITE")
+	bob.Expect(`^:irc.test.net 336 bob #test$`)
+	bob.Expect(`^:irc.test.net 337 bob :End of /INVITE list$`)
+
+	// the invite is used up by joining, and doesn't go on the +I list
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	bob.Send("PART #test")
+	alice.Expect(`^:bob!\S+ PART #test`)
+	bob.Send("JOIN #test")
+	bob.Expect(`^:irc.test.net 473 bob #test `)
+	bob.Send("INVITE")
+	bob.Expect(`^:irc.test.net 337 bob `)
+	alice.Send("MODE #test I")
+	alice.Expect(`^:irc.test.net 347 alice #test `)
+}
+
+func TestInviteTimeout(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.Server.InviteTimeout = 100 * time.Millisecond
+	})
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("MODE #test +i")
+	alice.Expect(`^:alice!\S+ MODE #test \+i$`)
+	alice.Send("INVITE bob #test")
+	bob.Expect(`^:alice!\S+ INVITE bob :#test$`)
+
+	time.Sleep(200 * time.Millisecond)
+	bob.Send("INVITE")
+	bob.Expect(`^:irc.test.net 337 bob `)
+	bob.Send("JOIN #test")
+	bob.Expect(`^:irc.test.net 473 bob #test `)
+}
+
+func TestInviteBanned(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	carol := server.Register("carol")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	alice.Send("MODE #test +b carol!*@*")
+	alice.Expect(`^:alice!\S+ MODE #test \+b carol!\*@\*$`)
+
+	// without +i any member may invite, but that's no way past a ban
+	bob.Send("INVITE carol #test")
+	carol.Expect(`^:bob!\S+ INVITE carol :#test$`)
+	carol.Send("JOIN #test")
+	carol.Expect(`^:irc.test.net 474 carol #test `)
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// maskExpiry is a timed list entry that ran out, handed to the server
+// goroutine to remove.
+type maskExpiry struct {
+	channel *Channel
+	mode    ChannelMode
+	mask    *UserMask
+}
+
+// MaxListToken returns the MAXLIST RPL_ISUPPORT token: the list modes share
+// a single limit.
+func MaxListToken(limit int) string {
+	return fmt.Sprintf("MAXLIST=%s:%d", ChannelModeTypes[0], limit)
+}
+
+// ParseDuration parses a ban duration: a number of seconds, or a Go
+// duration such as 1h30m.
+func ParseDuration(str string) (time.Duration, error) {
+	var duration time.Duration
+	if seconds, err := strconv.Atoi(str); err == nil {
+		duration = time.Duration(seconds) * time.Second
+	} else if duration, err = time.ParseDuration(str); err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", str)
+	}
+	return duration, nil
+}
+
+// listCount is how many entries the channel's lists hold together.
+func (channel *Channel) listCount() (count int) {
+	for _, mode := range ChannelModeTypes[0] {
+		count += channel.lists[mode].Count()
+	}
+	return
+}
+
+// addMask adds usermask to the list for mode as set by client, unless it is
+// already there or the lists are full. A mask with an expiry is removed
+// when it runs out.
+func (channel *Channel) addMask(client *Client, mode ChannelMode, usermask *UserMask) bool {
+	list := channel.lists[mode]
+	if list.masks[usermask.Key()] != nil {
+		return false
+	}
+	if channel.listCount() >= channel.server.config.MaxList() {
+		client.ErrBanListFull(channel, mode)
+		return false
+	}
+
+	usermask.setter = client.UserHost(true)
+	list.Add(usermask)
+	if !usermask.expires.IsZero() {
+		server := channel.server
+		usermask.timer = time.AfterFunc(time.Until(usermask.expires), func() {
+			select {
+			case server.maskExpiry <- maskExpiry{channel, mode, usermask}:
+			case <-server.done:
+			}
+		})
+	}
+	return true
+}
+
+// expireMask removes a mask that ran out and tells the members, unless it
+// was removed by hand or the channel is gone.
+func (channel *Channel) expireMask(mode ChannelMode, usermask *UserMask) {
+	if channel.server.channels.Get(channel.name) != channel {
+		return
+	}
+	if !channel.lists[mode].remove(usermask) {
+		return
+	}
+
+	change := &ChannelModeChange{mode: mode, op: Remove, arg: usermask.mask.String()}
+	reply := RplChannelMode(channel.server, channel, ChannelModeChanges{change})
+	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+		member.Reply(reply)
+		return true
+	})
+}
+
+// listNames names the list modes in replies.
+var listNames = map[ChannelMode]string{
+	BanMask:    "ban",
+	ExceptMask: "exception",
+	InviteMask: "invite",
+	QuietMask:  "quiet",
+}
+
+// TBAN adds a mask to one of a channel's lists, bans unless another list
+// mode is given, for a while and with an optional reason. A ban's reason
+// is shown to those it keeps out.
+func (msg *TBanCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	channel := server.channels.Get(msg.channel)
+	if channel == nil {
+		client.ErrNoSuchChannel(msg.channel)
+		return
+	}
+	if !channel.ClientIsOperator(client) {
+		client.ErrChanOPrivIsNeeded(channel)
+		return
+	}
+
+	usermask, err := NewUserMask(msg.mask)
+	if err != nil {
+		client.ErrInvalidModeParam(channel, msg.mode, msg.mask.String(), err.Error())
+		return
+	}
+	if channel.lists[msg.mode].masks[usermask.Key()] != nil {
+		client.Reply(RplNotice(server, client, NewText(fmt.Sprintf(
+			"%s is already on the %s %s list", usermask.mask, channel, listNames[msg.mode]))))
+		return
+	}
+	usermask.expires = usermask.setAt.Add(msg.duration)
+	usermask.reason = msg.reason
+	if !channel.addMask(client, msg.mode, usermask) {
+		return
+	}
+
+	change := &ChannelModeChange{mode: msg.mode, op: Add, arg: usermask.mask.String()}
+	reply := RplChannelMode(client, channel, ChannelModeChanges{change})
+	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+		member.Reply(reply)
+		return true
+	})
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	for str, expected := range map[string]time.Duration{
+		"60":    time.Minute,
+		"1h30m": 90 * time.Minute,
+	} {
+		duration, err := ParseDuration(str)
+		assert.NoError(err, str)
+		assert.Equal(expected, duration, str)
+	}
+	for _, str := range []string{"", "0", "-5", "soon"} {
+		_, err := ParseDuration(str)
+		assert.Error(err, str)
+	}
+}
+
+func TestMaskListMetadata(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("MODE #test +b bob")
+	alice.Expect(`^:alice!\S+ MODE #test \+b bob!\*@\*$`)
+	alice.Send("MODE #test +e carol")
+	alice.Expect(`^:alice!\S+ MODE #test \+e carol!\*@\*$`)
+
+	alice.Send("MODE #test b")
+	alice.Expect(`^:irc.test.net 367 alice #test bob!\*@\* alice!alice@\S+ \d+$`)
+	alice.Expect(`^:irc.test.net 368 alice #test `)
+	alice.Send("MODE #test e")
+	alice.Expect(`^:irc.test.net 348 alice #test carol!\*@\* alice!alice@\S+ \d+$`)
+	alice.Expect(`^:irc.test.net 349 alice #test `)
+}
+
+func TestMaxList(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.Server.MaxList = 2
+	})
+	alice := server.Connect()
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Expect(`^:irc.test.net 005 alice (\S+ )*MAXLIST=beIq:2 `)
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("MODE #test +b bob")
+	alice.Expect(`^:alice!\S+ MODE #test \+b bob!\*@\*$`)
+	alice.Send("MODE #test +q carol")
+	alice.Expect(`^:alice!\S+ MODE #test \+q carol!\*@\*$`)
+	alice.Send("MODE #test +I dave")
+	alice.Expect(`^:irc.test.net 478 alice #test I :Channel list is full$`)
+
+	alice.Send("MODE #test -b bob")
+	alice.Expect(`^:alice!\S+ MODE #test -b bob!\*@\*$`)
+	alice.Send("MODE #test +I dave")
+	alice.Expect(`^:alice!\S+ MODE #test \+I dave!\*@\*$`)
+}
+
+func TestTimedBan(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("TBAN #test 1 bob")
+	bob.Expect(`^:irc.test.net 482 bob #test `)
+	alice.Send("TBAN #test soon bob")
+	alice.Expect(`^:irc.test.net 400 alice TBAN `)
+
+	alice.Send("TBAN #test 1 bob :cool off")
+	alice.Expect(`^:alice!\S+ MODE #test \+b bob!\*@\*$`)
+	bob.Send("JOIN #test")
+	bob.Expect(`^:irc.test.net 474 bob #test :Cannot join channel \(\+b\) - cool off$`)
+
+	// the server lifts the ban when it runs out
+	alice.Expect(`^:irc.test.net MODE #test -b bob!\*@\*$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+}
+
+func TestTimedLists(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("TBAN #test q 1 carol :shouting")
+	alice.Expect(`^:alice!\S+ MODE #test \+q carol!\*@\*$`)
+	alice.Send("TBAN #test +e 1h dave")
+	alice.Expect(`^:alice!\S+ MODE #test \+e dave!\*@\*$`)
+	alice.Send("TBAN #test e 1h DAVE :again")
+	alice.Expect(`^:irc.test.net NOTICE alice :DAVE!\*@\* is already on the #test exception list$`)
+
+	alice.Send("MODE #test q")
+	alice.Expect(`^:irc.test.net 728 alice #test q carol!\*@\* alice!alice@\S+ \d+ :shouting$`)
+	alice.Expect(`^:irc.test.net 729 alice #test q `)
+	alice.Send("MODE #test e")
+	alice.Expect(`^:irc.test.net 348 alice #test dave!\*@\* alice!alice@\S+ \d+$`)
+
+	alice.Expect(`^:irc.test.net MODE #test -q carol!\*@\*$`)
+}
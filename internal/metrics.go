@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
 )
@@ -22,19 +23,29 @@ var DefObjectives = map[float64]float64{
 type Metrics struct {
 	sync.RWMutex
 
-	namespace string
-	metrics   map[string]prometheus.Metric
-	guagevecs map[string]*prometheus.GaugeVec
-	sumvecs   map[string]*prometheus.SummaryVec
+	namespace   string
+	registry    *prometheus.Registry
+	metrics     map[string]prometheus.Metric
+	countervecs map[string]*prometheus.CounterVec
+	guagevecs   map[string]*prometheus.GaugeVec
+	sumvecs     map[string]*prometheus.SummaryVec
 }
 
 // NewMetrics ...
 func NewMetrics(namespace string) *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
 	return &Metrics{
-		namespace: namespace,
-		metrics:   make(map[string]prometheus.Metric),
-		guagevecs: make(map[string]*prometheus.GaugeVec),
-		sumvecs:   make(map[string]*prometheus.SummaryVec),
+		namespace:   namespace,
+		registry:    registry,
+		metrics:     make(map[string]prometheus.Metric),
+		countervecs: make(map[string]*prometheus.CounterVec),
+		guagevecs:   make(map[string]*prometheus.GaugeVec),
+		sumvecs:     make(map[string]*prometheus.SummaryVec),
 	}
 }
 
@@ -53,7 +64,7 @@ func (m *Metrics) NewCounter(subsystem, name, help string) prometheus.Counter {
 	m.Lock()
 	m.metrics[key] = counter
 	m.Unlock()
-	prometheus.MustRegister(counter)
+	m.registry.MustRegister(counter)
 
 	return counter
 }
@@ -74,11 +85,32 @@ func (m *Metrics) NewCounterFunc(subsystem, name, help string, f func() float64)
 	m.Lock()
 	m.metrics[key] = counter
 	m.Unlock()
-	prometheus.MustRegister(counter)
+	m.registry.MustRegister(counter)
 
 	return counter
 }
 
+// NewCounterVec ...
+func (m *Metrics) NewCounterVec(subsystem, name, help string, labels []string) *prometheus.CounterVec {
+	countervec := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: m.namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)
+
+	key := fmt.Sprintf("%s_%s", subsystem, name)
+	m.Lock()
+	m.countervecs[key] = countervec
+	m.Unlock()
+	m.registry.MustRegister(countervec)
+
+	return countervec
+}
+
 // NewGauge ...
 func (m *Metrics) NewGauge(subsystem, name, help string) prometheus.Gauge {
 	guage := prometheus.NewGauge(
@@ -94,7 +126,7 @@ func (m *Metrics) NewGauge(subsystem, name, help string) prometheus.Gauge {
 	m.Lock()
 	m.metrics[key] = guage
 	m.Unlock()
-	prometheus.MustRegister(guage)
+	m.registry.MustRegister(guage)
 
 	return guage
 }
@@ -115,7 +147,7 @@ func (m *Metrics) NewGaugeFunc(subsystem, name, help string, f func() float64) p
 	m.Lock()
 	m.metrics[key] = guage
 	m.Unlock()
-	prometheus.MustRegister(guage)
+	m.registry.MustRegister(guage)
 
 	return guage
 }
@@ -136,7 +168,7 @@ func (m *Metrics) NewGaugeVec(subsystem, name, help string, labels []string) *pr
 	m.Lock()
 	m.guagevecs[key] = guagevec
 	m.Unlock()
-	prometheus.MustRegister(guagevec)
+	m.registry.MustRegister(guagevec)
 
 	return guagevec
 }
@@ -157,7 +189,7 @@ func (m *Metrics) NewSummary(subsystem, name, help string) prometheus.Summary {
 	m.Lock()
 	m.metrics[key] = summary
 	m.Unlock()
-	prometheus.MustRegister(summary)
+	m.registry.MustRegister(summary)
 
 	return summary
 }
@@ -179,7 +211,7 @@ func (m *Metrics) NewSummaryVec(subsystem, name, help string, labels []string) *
 	m.Lock()
 	m.sumvecs[key] = sumvec
 	m.Unlock()
-	prometheus.MustRegister(sumvec)
+	m.registry.MustRegister(sumvec)
 
 	return sumvec
 }
@@ -190,6 +222,14 @@ func (m *Metrics) Counter(subsystem, name string) prometheus.Counter {
 	return m.metrics[key].(prometheus.Counter)
 }
 
+// CounterVec ...
+func (m *Metrics) CounterVec(subsystem, name string) *prometheus.CounterVec {
+	key := fmt.Sprintf("%s_%s", subsystem, name)
+	m.RLock()
+	defer m.RUnlock()
+	return m.countervecs[key]
+}
+
 // Gauge ...
 func (m *Metrics) Gauge(subsystem, name string) prometheus.Gauge {
 	key := fmt.Sprintf("%s_%s", subsystem, name)
@@ -224,12 +264,18 @@ func (m *Metrics) SummaryVec(subsystem, name string) *prometheus.SummaryVec {
 
 // Handler ...
 func (m *Metrics) Handler() http.Handler {
-	return promhttp.Handler()
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Mount serves the metrics on mux under path.
+func (m *Metrics) Mount(mux *http.ServeMux, path string) {
+	mux.Handle(path, m.Handler())
 }
 
 // Run ...
-func (m *Metrics) Run(addr string) {
-	http.Handle("/", m.Handler())
-	log.Infof("metrics endpoint listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+func (m *Metrics) Run(addr, path string) {
+	mux := http.NewServeMux()
+	m.Mount(mux, path)
+	log.Infof("metrics endpoint listening on %s%s", addr, path)
+	log.Fatal(http.ListenAndServe(addr, mux))
 }
@@ -3,6 +3,8 @@ package internal
 import (
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,3 +51,107 @@ test_foo_gauge_vec{test="test"} 1
 		w.Body.String(),
 	)
 }
+
+func TestMetricsMount(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMetrics("test")
+	m.NewCounterVec("foo", "counter_vec", "help", []string{"test"})
+	m.CounterVec("foo", "counter_vec").WithLabelValues("a").Inc()
+	m.CounterVec("foo", "counter_vec").WithLabelValues("b").Inc()
+	m.CounterVec("foo", "counter_vec").DeleteLabelValues("b")
+
+	mux := http.NewServeMux()
+	m.Mount(mux, "/metrics")
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/metrics", nil)
+	mux.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), `test_foo_counter_vec{test="a"} 1`)
+	assert.NotContains(w.Body.String(), `test_foo_counter_vec{test="b"}`)
+
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/", nil)
+	mux.ServeHTTP(w, r)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestServerMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+	alice.Send("PRIVMSG #test :one")
+	bob.Expect(`PRIVMSG #test :one$`)
+	alice.Send("NOTICE #test :two")
+	bob.Expect(`NOTICE #test :two$`)
+	bob.Send("AUTHENTICATE PLAIN")
+	bob.Expect(` 421 bob AUTHENTICATE `)
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/metrics", nil)
+		server.metrics.Handler().ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	body := scrape()
+	assert.Contains(body, `eris_channel_messages{channel="#test"} 2`)
+	assert.Contains(body, `eris_client_command_calls{command="JOIN"} 2`)
+	assert.Contains(body, `eris_client_command_calls{command="PRIVMSG"} 1`)
+
+	// the channel's series goes away with the channel
+	alice.Send("PART #test")
+	alice.Expect(`^:alice!\S+ PART #test`)
+	bob.Send("PART #test")
+	bob.Expect(`^:bob!\S+ PART #test`)
+	bob.ExpectNone(`.`)
+	assert.NotContains(scrape(), `eris_channel_messages{channel="#test"}`)
+
+	// secret channels are counted without their names
+	alice.Send("JOIN #secret")
+	alice.Expect(`^:alice!\S+ JOIN #secret$`)
+	alice.Send("PRIVMSG #secret :one")
+	alice.Send("MODE #secret +s")
+	alice.Expect(` MODE #secret \+s$`)
+	alice.Send("PRIVMSG #secret :two")
+	alice.ExpectNone(`.`)
+	body = scrape()
+	assert.NotContains(body, `#secret`)
+	assert.Contains(body, `eris_channel_messages{channel="*"} 1`)
+}
+
+func TestMetricsListen(t *testing.T) {
+	assert := assert.New(t)
+
+	load := func(metrics string) MetricsConfig {
+		filename := filepath.Join(t.TempDir(), "ircd.yml")
+		err := os.WriteFile(filename, []byte(`
+network:
+  name: TestNet
+server:
+  name: irc.test.net
+  listen: ["127.0.0.1:0"]
+metrics:
+`+metrics), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config.Metrics
+	}
+
+	assert.Equal(DefaultMetricsListen, load("  path: /metrics\n").Listen)
+	assert.Equal("", load("  www: true\n").Listen)
+	assert.Equal(":9000", load("  www: true\n  listen: :9000\n").Listen)
+}
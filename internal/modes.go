@@ -57,11 +57,13 @@ const (
 	SecureConn UserMode = 'z'
 	SecureOnly UserMode = 'Z'
 	HostMask   UserMode = 'x'
+	CallerID   UserMode = 'g' // only accepted clients may message
+	RegOnlyMsg UserMode = 'R' // only logged in clients may message
 )
 
 var (
 	SupportedUserModes = UserModes{
-		Invisible, Operator, HostMask,
+		Invisible, Operator, HostMask, CallerID, RegOnlyMsg,
 	}
 	DefaultChannelModes = ChannelModes{
 		NoOutside, OpOnlyTopic,
@@ -84,15 +86,47 @@ const (
 	UserLimit       ChannelMode = 'l' // flag arg
 	Voice           ChannelMode = 'v' // arg
 	SecureChan      ChannelMode = 'Z' // arg
+	QuietMask       ChannelMode = 'q' // arg
+	JoinThrottle    ChannelMode = 'j' // flag arg
+	NoColors        ChannelMode = 'c' // flag
+	StripColors     ChannelMode = 'S' // flag
+	NoCTCP          ChannelMode = 'C' // flag
+	RegisteredOnly  ChannelMode = 'R' // flag
+	RegisteredSpeak ChannelMode = 'M' // flag
+	NoNickChange    ChannelMode = 'N' // flag
+	Redirect        ChannelMode = 'L' // flag arg
 )
 
 var (
 	SupportedChannelModes = ChannelModes{
 		BanMask, ExceptMask, InviteMask, InviteOnly, Key, NoOutside,
-		OpOnlyTopic, Private, UserLimit, Secret, SecureChan,
+		OpOnlyTopic, Private, UserLimit, Secret, SecureChan, QuietMask,
+		JoinThrottle, NoColors, StripColors, NoCTCP, RegisteredOnly,
+		RegisteredSpeak, NoNickChange, Redirect,
+	}
+
+	// ChannelModeTypes sorts the channel modes the way CHANMODES
+	// advertises them: list modes, modes that always take a parameter,
+	// modes that only take one when set, and plain flags.
+	ChannelModeTypes = [4]ChannelModes{
+		{BanMask, ExceptMask, InviteMask, QuietMask},
+		{Key},
+		{UserLimit, JoinThrottle, Redirect},
+		{InviteOnly, Moderated, NoOutside, OpOnlyTopic, Private, Secret,
+			SecureChan, NoColors, StripColors, NoCTCP, RegisteredOnly,
+			RegisteredSpeak, NoNickChange},
 	}
 )
 
+// ChanModesToken returns the CHANMODES RPL_ISUPPORT token.
+func ChanModesToken() string {
+	types := make([]string, len(ChannelModeTypes))
+	for index, modes := range ChannelModeTypes {
+		types[index] = modes.String()
+	}
+	return "CHANMODES=" + strings.Join(types, ",")
+}
+
 //
 // commands
 //
@@ -106,7 +140,7 @@ func (m *ModeCommand) HandleServer(s *Server) {
 		return
 	}
 
-	if client != target && !client.modes.Has(Operator) {
+	if client != target && !client.HasPrivilege(PrivKill) {
 		client.ErrUsersDontMatch()
 		return
 	}
@@ -115,7 +149,7 @@ func (m *ModeCommand) HandleServer(s *Server) {
 
 	for _, change := range m.changes {
 		switch change.mode {
-		case Invisible, HostMask, WallOps, SecureOnly:
+		case Invisible, HostMask, WallOps, SecureOnly, CallerID, RegOnlyMsg:
 			switch change.op {
 			case Add:
 				if target.modes.Has(change.mode) {
@@ -0,0 +1,137 @@
+package internal
+
+// MonitorIndex maps nicks to the clients monitoring them, so that coming
+// and going can be pushed to them instead of being polled with ISON. Like
+// all client state it belongs to the server goroutine.
+type MonitorIndex struct {
+	watchers map[Name]map[*Client]bool
+}
+
+func NewMonitorIndex() *MonitorIndex {
+	return &MonitorIndex{
+		watchers: make(map[Name]map[*Client]bool),
+	}
+}
+
+// Add makes client monitor nick.
+func (index *MonitorIndex) Add(client *Client, nick Name) {
+	key := nick.ToLower()
+	if index.watchers[key] == nil {
+		index.watchers[key] = make(map[*Client]bool)
+	}
+	index.watchers[key][client] = true
+	client.monitoring[key] = nick
+}
+
+// Remove stops client monitoring nick.
+func (index *MonitorIndex) Remove(client *Client, nick Name) {
+	key := nick.ToLower()
+	delete(index.watchers[key], client)
+	if len(index.watchers[key]) == 0 {
+		delete(index.watchers, key)
+	}
+	delete(client.monitoring, key)
+}
+
+// RemoveAll clears the client's monitor list.
+func (index *MonitorIndex) RemoveAll(client *Client) {
+	for _, nick := range client.monitoring {
+		index.Remove(client, nick)
+	}
+}
+
+// Range calls f for every client monitoring nick.
+func (index *MonitorIndex) Range(nick Name, f func(watcher *Client)) {
+	for watcher := range index.watchers[nick.ToLower()] {
+		f(watcher)
+	}
+}
+
+// monitorOnline tells the clients monitoring the client's nick that it is
+// online, which it is once registered.
+func (c *Client) monitorOnline() {
+	target := c.nick.String()
+	if c.HasUsername() {
+		target = c.UserHost(true).String()
+	}
+	c.server.monitors.Range(c.nick, func(watcher *Client) {
+		watcher.RplMonOnline([]string{target})
+	})
+}
+
+// monitorOffline tells the clients monitoring nick that it went offline.
+func (server *Server) monitorOffline(nick Name) {
+	server.monitors.Range(nick, func(watcher *Client) {
+		watcher.RplMonOffline([]string{nick.String()})
+	})
+}
+
+func (msg *MonitorCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	switch msg.subCommand {
+	case "+":
+		limit := server.config.MonitorLimit()
+		var online, offline []string
+		for index, target := range msg.targets {
+			if _, ok := client.monitoring[target.ToLower()]; ok {
+				continue
+			}
+			if len(client.monitoring) >= limit {
+				client.ErrMonListFull(limit, namesToStrings(msg.targets[index:]))
+				break
+			}
+			server.monitors.Add(client, target)
+			if other := server.clients.Get(target); other != nil && other.registered {
+				online = append(online, other.UserHost(true).String())
+			} else {
+				offline = append(offline, target.String())
+			}
+		}
+		client.rplMonStatus(online, offline)
+
+	case "-":
+		for _, target := range msg.targets {
+			server.monitors.Remove(client, target)
+		}
+
+	case "C":
+		server.monitors.RemoveAll(client)
+
+	case "L":
+		nicks := make([]string, 0, len(client.monitoring))
+		for _, nick := range client.monitoring {
+			nicks = append(nicks, nick.String())
+		}
+		client.RplMonList(nicks)
+		client.RplEndOfMonList()
+
+	case "S":
+		var online, offline []string
+		for _, nick := range client.monitoring {
+			if other := server.clients.Get(nick); other != nil && other.registered {
+				online = append(online, other.UserHost(true).String())
+			} else {
+				offline = append(offline, nick.String())
+			}
+		}
+		client.rplMonStatus(online, offline)
+	}
+}
+
+func (client *Client) rplMonStatus(online []string, offline []string) {
+	if len(online) > 0 {
+		client.RplMonOnline(online)
+	}
+	if len(offline) > 0 {
+		client.RplMonOffline(offline)
+	}
+}
+
+func namesToStrings(names []Name) []string {
+	strs := make([]string, len(names))
+	for index, name := range names {
+		strs[index] = name.String()
+	}
+	return strs
+}
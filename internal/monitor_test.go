@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+
+	alice.Send("MONITOR + bob,Carol")
+	alice.Expect(`^:irc.test.net 731 alice :bob,Carol$`)
+
+	bob := server.Register("bob")
+	alice.Expect(`^:irc.test.net 730 alice :bob!?\S*$`)
+
+	alice.Send("MONITOR L")
+	alice.Expect(`^:irc.test.net 732 alice :(bob,Carol|Carol,bob)$`)
+	alice.Expect(`^:irc.test.net 733 alice :End of MONITOR list$`)
+
+	bob.Send("NICK carol")
+	alice.Expect(`^:irc.test.net 731 alice :bob$`)
+	alice.Expect(`^:irc.test.net 730 alice :carol!bob@\S+$`)
+
+	alice.Send("MONITOR S")
+	alice.Expect(`^:irc.test.net 730 alice :carol!bob@\S+$`)
+	alice.Expect(`^:irc.test.net 731 alice :bob$`)
+
+	alice.Send("MONITOR - carol")
+	bob.Send("QUIT")
+	alice.ExpectNone(` 731 `)
+
+	alice.Send("MONITOR C")
+	alice.Send("MONITOR L")
+	alice.Expect(`^:irc.test.net 733 alice `)
+
+	// a nick is only online once its client registers
+	alice.Send("MONITOR + dave,erin")
+	alice.Expect(`^:irc.test.net 731 alice :dave,erin$`)
+	dave := server.Connect()
+	dave.Send("NICK dave")
+	alice.Send("MONITOR S")
+	alice.Expect(`^:irc.test.net 731 alice :(dave,erin|erin,dave)$`)
+	dave.Send("USER dave 0 * :Dave")
+	dave.Expect(` 001 dave `)
+	alice.Expect(`^:irc.test.net 730 alice :dave!dave@\S+$`)
+
+	erin := server.Connect()
+	erin.Send("NICK erin")
+	erin.Send("QUIT")
+	erin.ExpectClosed()
+	alice.ExpectNone(`erin`)
+}
+
+func TestMonitorLimit(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.Server.MonitorLimit = 2
+	})
+	alice := server.Connect()
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Expect(`^:irc.test.net 005 alice (\S+ )*MONITOR=2 (\S+ )*:are supported by this server$`)
+
+	alice.Send("MONITOR + a,b,c,d")
+	alice.Expect(`^:irc.test.net 734 alice 2 c,d :Monitor list is full$`)
+	alice.Expect(`^:irc.test.net 731 alice :a,b$`)
+}
@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"strconv"
+)
+
+// MultilineConcat marks a line that continues the previous one rather
+// than starting a new line.
+const MultilineConcat = "draft/multiline-concat"
+
+type multilineLine struct {
+	text   Text
+	concat bool
+}
+
+// multilineBatch is a draft/multiline batch a client is sending.
+type multilineBatch struct {
+	ref    string
+	target Name
+	code   StringCode // PRIVMSG or NOTICE, set by the first line
+	tags   Tags       // sent with BATCH +, and relayed with the message
+	lines  []multilineLine
+	bytes  int
+	failed bool // the rest of the batch is dropped
+}
+
+// Text returns the lines of the batch joined as the recipient should see
+// them, with concatenated lines merged into the line they continue.
+func (batch *multilineBatch) Text() []Text {
+	texts := make([]Text, 0, len(batch.lines))
+	for _, line := range batch.lines {
+		if line.concat && len(texts) > 0 {
+			texts[len(texts)-1] += line.text
+			continue
+		}
+		texts = append(texts, line.text)
+	}
+	return texts
+}
+
+func (msg *BatchCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	if msg.start {
+		switch {
+		case msg.batchType != BatchMultiline || !client.capabilities[Multiline]:
+			client.StandardReply(FailBatchUnknownType, msg.batchType)
+		case client.multiline != nil:
+			client.StandardReply(FailMultilineNested)
+		case len(msg.params) < 1:
+			client.StandardReply(FailMultilineNoTarget)
+		default:
+			client.multiline = &multilineBatch{
+				ref:    msg.ref,
+				target: NewName(msg.params[0]),
+				tags:   msg.Tags(),
+			}
+		}
+		return
+	}
+
+	batch := client.multiline
+	if batch == nil || batch.ref != msg.ref {
+		client.StandardReply(FailMultilineNoSuchBatch, msg.ref)
+		return
+	}
+	client.multiline = nil
+
+	if batch.failed {
+		return
+	}
+	if len(batch.lines) == 0 {
+		client.StandardReply(FailMultilineEmpty)
+		return
+	}
+	server.sendMultiline(client, batch)
+}
+
+// addToMultiline adds a PRIVMSG or NOTICE sent in the batch ref to the
+// client's multiline batch, failing the batch if it breaks the rules.
+func (client *Client) addToMultiline(ref string, code StringCode, target Name, message Text, tags Tags) {
+	server := client.server
+	batch := client.multiline
+	if batch == nil || batch.ref != ref {
+		client.StandardReply(FailMultilineNoSuchBatch, ref)
+		return
+	}
+	if batch.failed {
+		return
+	}
+
+	fail := func(reply StandardReply, context ...string) {
+		client.StandardReply(reply, context...)
+		batch.failed = true
+	}
+
+	_, concat := tags[MultilineConcat]
+	if batch.code == "" {
+		batch.code = code
+	}
+	switch {
+	case target != batch.target:
+		fail(FailMultilineTarget, batch.target.String(), target.String())
+		return
+	case code != batch.code:
+		fail(FailMultilineMixed)
+		return
+	case concat && message == "":
+		fail(FailMultilineBlankConcat)
+		return
+	}
+
+	batch.bytes += len(message)
+	if !concat && len(batch.lines) > 0 {
+		batch.bytes += len("\n")
+	}
+	batch.lines = append(batch.lines, multilineLine{message, concat})
+
+	if maxBytes := server.config.MultilineMaxBytes(); batch.bytes > maxBytes {
+		fail(FailMultilineMaxBytes, strconv.Itoa(maxBytes))
+	} else if maxLines := server.config.MultilineMaxLines(); len(batch.lines) > maxLines {
+		fail(FailMultilineMaxLines, strconv.Itoa(maxLines))
+	}
+}
+
+// sendMultiline delivers a finished batch like a single PRIVMSG or NOTICE.
+func (server *Server) sendMultiline(client *Client, batch *multilineBatch) {
+	tags := client.MessageTags(batch.tags)
+
+	if batch.target.IsChannel() {
+		channel := server.channels.Get(batch.target)
+		if channel == nil {
+			client.ErrNoSuchChannel(batch.target)
+			return
+		}
+		if !channel.CanSpeak(client) {
+			client.ErrCannotSendToChan(channel)
+			return
+		}
+		if !client.filterMultiline(FilterChannel, channel.name, batch) {
+			return
+		}
+		for index, line := range batch.lines {
+			text, mode := channel.FilterMessage(client, line.text)
+			if mode != 0 {
+				client.ErrCannotSendToChanMode(channel, mode)
+				return
+			}
+			batch.lines[index].text = text
+		}
+		span := channel.fanout(client, "BATCH "+batch.code.String())
+		defer span.End()
+
+		channel.countMessage()
+		channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
+			if member == client && !client.capabilities[EchoMessage] {
+				return true
+			}
+			if member != client {
+				server.metrics.Counter("client", "messages").Inc()
+			}
+			member.replyMultiline(client, channel, batch, tags)
+			return true
+		})
+		return
+	}
+
+	target := server.clients.Get(batch.target)
+	if target == nil {
+		client.ErrNoSuchNick(batch.target)
+		return
+	}
+	if !client.filterMultiline(FilterPrivate, target.nick, batch) {
+		return
+	}
+	if !client.CanSpeak(target) {
+		client.ErrCannotSendToUser(target.nick, "secure connection required")
+		return
+	}
+	if !client.CanMessage(target, batch.code) {
+		return
+	}
+	server.metrics.Counter("client", "messages").Inc()
+	target.replyMultiline(client, target, batch, tags)
+	if client.capabilities[EchoMessage] {
+		client.replyMultiline(client, target, batch, tags)
+	}
+	if batch.code == PRIVMSG {
+		client.acceptReplies(target)
+	}
+	if batch.code == PRIVMSG && target.modes.Has(Away) {
+		client.RplAway(target)
+	}
+}
+
+// filterMultiline filters each message of the batch, as it would be if
+// sent on its own.
+func (c *Client) filterMultiline(scope FilterScope, target Name, batch *multilineBatch) bool {
+	for _, text := range batch.Text() {
+		if !c.Filter(scope, target, text) {
+			return false
+		}
+	}
+	return true
+}
+
+// replyMultiline sends the client a batch from source, as a batch if it
+// supports draft/multiline and otherwise as one message per line.
+func (c *Client) replyMultiline(source *Client, target Identifiable, batch *multilineBatch, tags Tags) {
+	if !c.capabilities[Multiline] || !c.capabilities[Batch] {
+		// sent as one split message, so that each line gets its own msgid
+		var replies []string
+		for _, text := range batch.Text() {
+			replies = append(replies, messageReplies(batch.code, source, target, text)...)
+		}
+		c.ReplyLinesWithTags(replies, tags)
+		return
+	}
+
+	id := c.nextBatchID()
+	c.ReplyWithTags(RplBatchStart(source, id, BatchMultiline, target.Nick().String()), tags)
+	for _, line := range batch.lines {
+		// a line too long to relay continues in concatenated lines
+		for index, reply := range messageReplies(batch.code, source, target, line.text) {
+			lineTags := Tags{"batch": id}
+			if line.concat || index > 0 {
+				lineTags[MultilineConcat] = ""
+			}
+			c.ReplyWithTags(reply, lineTags)
+		}
+	}
+	c.ReplyWithTags(RplBatchEnd(source, id), nil)
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiline(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "draft/multiline", "batch", "echo-message")
+	bob := server.RegisterWithCaps("bob", "draft/multiline", "batch")
+	carol := server.Register("carol")
+
+	for _, client := range []*testClient{alice, bob, carol} {
+		client.Send("JOIN #test")
+		client.Expect(`^:%s!\S+ JOIN #test$`, client.nick)
+	}
+
+	alice.Send("BATCH +ml draft/multiline #test")
+	alice.Send("@batch=ml PRIVMSG #test :panic: oops")
+	alice.Send("@batch=ml PRIVMSG #test :  at main.go:")
+	alice.Send("@batch=ml;draft/multiline-concat PRIVMSG #test :42")
+	alice.Send("BATCH -ml")
+
+	for _, client := range []*testClient{alice, bob} {
+		start := client.Expect(`^:alice!\S+ BATCH \+(\S+) draft/multiline #test$`)
+		id := start[1]
+		client.Expect(`^@batch=%s :alice!\S+ PRIVMSG #test :panic: oops$`, id)
+		client.Expect(`^@batch=%s :alice!\S+ PRIVMSG #test :  at main.go:$`, id)
+		client.Expect(`^@batch=%s;draft/multiline-concat :alice!\S+ PRIVMSG #test :42$`, id)
+		client.Expect(`^:alice!\S+ BATCH -%s$`, id)
+	}
+
+	// without the capability, each line is a message of its own
+	carol.Expect(`^:alice!\S+ PRIVMSG #test :panic: oops$`)
+	carol.Expect(`^:alice!\S+ PRIVMSG #test :  at main.go:42$`)
+	carol.ExpectNone(`BATCH|PRIVMSG`)
+}
+
+func TestMultilineLimits(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.Multiline.MaxLines = 2
+	})
+	alice := server.RegisterWithCaps("alice", "draft/multiline", "batch")
+	bob := server.Register("bob")
+
+	alice.Send("CAP LS 302")
+	alice.Expect(`CAP alice LS :.*draft/multiline=max-bytes=4096,max-lines=2 `)
+
+	alice.Send("BATCH +a draft/multiline bob")
+	alice.Send("@batch=a PRIVMSG bob :one")
+	alice.Send("@batch=a PRIVMSG bob :two")
+	alice.Send("@batch=a PRIVMSG bob :three")
+	alice.Send("BATCH -a")
+	alice.Expect(`^:irc.test.net FAIL BATCH MULTILINE_MAX_LINES 2 :`)
+
+	alice.Send("BATCH +b draft/multiline bob")
+	alice.Send("@batch=b PRIVMSG carol :one")
+	alice.Send("BATCH -b")
+	alice.Expect(`^:irc.test.net FAIL BATCH MULTILINE_INVALID_TARGET bob carol :`)
+
+	alice.Send("BATCH -c")
+	alice.Expect(`^:irc.test.net FAIL BATCH MULTILINE_INVALID c :`)
+
+	bob.ExpectNone(`PRIVMSG`)
+}
+
+func TestFloodControl(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True((*FloodControl)(nil).Allow(time.Now()))
+
+	flood := NewFloodControl(FloodConfig{Messages: 2, Period: time.Second})
+	now := time.Now()
+	assert.True(flood.Allow(now))
+	assert.True(flood.Allow(now))
+	assert.False(flood.Allow(now))
+	assert.True(flood.Allow(now.Add(time.Second)))
+
+	// a multiline batch counts as one message
+	server := newTestServer(t, func(config *Config) {
+		config.Server.Flood = FloodConfig{Messages: 2, Period: time.Hour}
+	})
+	alice := server.RegisterWithCaps("alice", "draft/multiline", "batch")
+	bob := server.Register("bob")
+
+	alice.Send("BATCH +a draft/multiline bob")
+	for i := 0; i < 5; i++ {
+		alice.Send("@batch=a PRIVMSG bob :line %d", i)
+	}
+	alice.Send("BATCH -a")
+	alice.Send("PRIVMSG bob :one more")
+	bob.Expect(`PRIVMSG bob :line 4$`)
+	bob.Expect(`PRIVMSG bob :one more$`)
+
+	alice.Send("PRIVMSG bob :too many")
+	alice.Expect(`^ERROR :Excess Flood$`)
+	alice.ExpectClosed()
+	bob.ExpectNone(`too many`)
+}
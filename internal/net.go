@@ -5,6 +5,16 @@ import (
 	"strings"
 )
 
+// Transport is what a client connected over.
+type Transport string
+
+const (
+	TransportTCP Transport = "TCP"
+	TransportTLS Transport = "TLS"
+	TransportI2P Transport = "I2P"
+	TransportTor Transport = "Tor"
+)
+
 func IPString(addr net.Addr) Name {
 	addrStr := addr.String()
 	ipaddr, _, err := net.SplitHostPort(addrStr)
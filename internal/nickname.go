@@ -27,6 +27,9 @@ func (m *NickCommand) HandleRegServer(s *Server) {
 		client.ErrErroneusNickname(m.nickname)
 		return
 	}
+	if !client.Filter(FilterNick, "", m.nickname.Text()) {
+		return
+	}
 
 	client.SetNickname(m.nickname)
 	s.tryRegister(client)
@@ -44,6 +47,9 @@ func (msg *NickCommand) HandleServer(server *Server) {
 		client.ErrErroneusNickname(msg.nickname)
 		return
 	}
+	if !client.Filter(FilterNick, "", msg.nickname.Text()) {
+		return
+	}
 
 	if msg.nickname == client.nick {
 		return
@@ -55,6 +61,19 @@ func (msg *NickCommand) HandleServer(server *Server) {
 		return
 	}
 
+	var locked *Channel
+	client.channels.Range(func(channel *Channel) bool {
+		if channel.flags.Has(NoNickChange) && !channel.ClientIsOperator(client) {
+			locked = channel
+			return false
+		}
+		return true
+	})
+	if locked != nil {
+		client.ErrNoNickChange(locked)
+		return
+	}
+
 	client.ChangeNickname(msg.nickname)
 }
 
@@ -67,7 +86,7 @@ type OperNickCommand struct {
 func (msg *OperNickCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
-	if !client.modes.Has(Operator) {
+	if !client.HasPrivilege(PrivKill) {
 		client.ErrNoPrivileges()
 		return
 	}
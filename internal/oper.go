@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goshuirc/irc-go/ircmatch"
+)
+
+// OperPrivilege is something an operator class may allow.
+type OperPrivilege string
+
+const (
+	PrivKill            OperPrivilege = "kill"             // KILL and ONICK
+	PrivRehash          OperPrivilege = "rehash"           // REHASH
+	PrivBan             OperPrivilege = "ban"              // server bans
+	PrivSeeHosts        OperPrivilege = "see-hosts"        // real hosts, CHECK, STATS l
+	PrivOverrideChannel OperPrivilege = "override-channel" // act as a channel operator anywhere
+	PrivGlobalNotice    OperPrivilege = "global-notice"    // NOTICE *
+	PrivVHost           OperPrivilege = "vhost"            // VHOST
+	PrivFilter          OperPrivilege = "filter"           // FILTER
+)
+
+var OperPrivileges = []OperPrivilege{
+	PrivKill, PrivRehash, PrivBan, PrivSeeHosts, PrivOverrideChannel, PrivGlobalNotice,
+	PrivVHost, PrivFilter,
+}
+
+// OperClass is a named set of privileges granted to operators.
+type OperClass struct {
+	Name       string
+	Privileges map[OperPrivilege]bool
+}
+
+// DefaultOperClass is the class of operators configured without one: they
+// get every privilege, as all operators used to.
+var DefaultOperClass = NewOperClass("", OperPrivileges)
+
+func NewOperClass(name string, privileges []OperPrivilege) *OperClass {
+	class := &OperClass{
+		Name:       name,
+		Privileges: make(map[OperPrivilege]bool),
+	}
+	for _, privilege := range privileges {
+		class.Privileges[privilege] = true
+	}
+	return class
+}
+
+func ParseOperPrivilege(str string) (OperPrivilege, error) {
+	for _, privilege := range OperPrivileges {
+		if string(privilege) == str {
+			return privilege, nil
+		}
+	}
+	return "", fmt.Errorf("unknown operator privilege: %s", str)
+}
+
+// Oper is an operator block from the config.
+type Oper struct {
+	name     Name
+	password []byte
+	class    *OperClass
+	hosts    []Name // masks the operator may log in from
+	secure   bool   // TLS required
+	certfp   string // client certificate required, if set
+	account  string // account required, if set
+}
+
+var (
+	ErrOperHost    = errors.New("host not allowed")
+	ErrOperSecure  = errors.New("TLS required")
+	ErrOperCertFP  = errors.New("certificate fingerprint mismatch")
+	ErrOperAccount = errors.New("not logged in to the operator's account")
+)
+
+// CheckLogin checks that the client meets the operator block's
+// restrictions, past the password.
+func (oper *Oper) CheckLogin(c *Client) error {
+	if len(oper.hosts) > 0 && !oper.matchesHost(c) {
+		return ErrOperHost
+	}
+	if oper.secure && !c.modes.Has(SecureConn) {
+		return ErrOperSecure
+	}
+	if oper.certfp != "" && c.certfp != oper.certfp {
+		return ErrOperCertFP
+	}
+	if oper.account != "" && c.Account() != oper.account {
+		return ErrOperAccount
+	}
+	return nil
+}
+
+// matchesHost matches the real host and the IP of the client, never the
+// cloak, against the operator's host masks.
+func (oper *Oper) matchesHost(c *Client) bool {
+	userhosts := []string{
+		c.UserHost(false).String(),
+		fmt.Sprintf("%s!%s@%s", c.nick, c.username, c.ip),
+	}
+	for _, mask := range oper.hosts {
+		matcher := ircmatch.MakeMatch(ExpandUserHost(mask).String())
+		for _, userhost := range userhosts {
+			if matcher.Match(userhost) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CertFP returns the SHA-256 fingerprint of the client certificate
+// presented on conn, or "" if there is none.
+func CertFP(conn *tls.Conn) string {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeCertFP puts a fingerprint as written in the config, maybe in
+// upper case or with colons, in the form CertFP returns.
+func NormalizeCertFP(certfp string) string {
+	return strings.ToLower(strings.ReplaceAll(certfp, ":", ""))
+}
+
+// HasPrivilege reports whether the client is an operator whose class
+// allows privilege.
+func (c *Client) HasPrivilege(privilege OperPrivilege) bool {
+	return c.modes.Has(Operator) && c.operClass != nil && c.operClass.Privileges[privilege]
+}
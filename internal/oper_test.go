@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withOperClass configures an operator "helper", password "secret", in a
+// class of the same name with the given privileges.
+func withOperClass(t *testing.T, privileges ...string) func(*Config) {
+	return func(config *Config) {
+		config.OperClass = map[string]*OperClassConfig{
+			"helper": {Privileges: privileges},
+		}
+		config.Operator = map[string]*OperConfig{
+			"helper": {
+				PassConfig: PassConfig{Password: testPassword(t, "secret")},
+				Class:      "helper",
+			},
+		}
+	}
+}
+
+func TestOperClass(t *testing.T) {
+	server := newTestServer(t, withOperClass(t, "see-hosts"))
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("OPER helper secret")
+	alice.Expect(` 381 alice `)
+
+	bob.Send("WHOIS alice")
+	bob.Expect(`^:irc.test.net 313 bob alice :is an IRC operator \(helper\)$`)
+
+	alice.Send("CHECK bob")
+	alice.Expect(`:\[CHECK bob\] End of CHECK$`)
+	alice.Send("TRACE alice")
+	alice.Expect(`^:irc.test.net 204 alice Oper helper alice$`)
+
+	alice.Send("KILL bob :bye")
+	alice.Expect(`^:irc.test.net 481 alice `)
+	alice.Send("REHASH")
+	alice.Expect(`^:irc.test.net 481 alice `)
+	alice.Send("STATS k")
+	alice.Expect(`^:irc.test.net 481 alice `)
+
+	// no override-channel: keys and bans apply
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+	bob.Send("MODE #test +k key")
+	bob.Expect(` MODE #test \+k key$`)
+	alice.Send("JOIN #test")
+	alice.Expect(`^:irc.test.net 475 alice #test `)
+}
+
+func TestOperClassWithoutSeeHosts(t *testing.T) {
+	server := newTestServer(t, withOperClass(t, "kill"))
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	server.Connect()
+
+	bob.Send("MODE bob +i")
+	bob.Expect(` MODE bob :?\+i$`)
+	alice.Send("OPER helper secret")
+	alice.Expect(` 381 alice `)
+
+	// neither unregistered connections nor invisible users show up
+	alice.Send("TRACE")
+	alice.ExpectNone(` 203 `)
+	alice.Send("WHO bob")
+	alice.ExpectNone(` 352 `)
+
+	// nor do failed OPER attempts, which show hosts
+	bob.Send("OPER helper wrong")
+	bob.Expect(` 464 bob `)
+	alice.ExpectNone(`Failed OPER attempt`)
+}
+
+func TestOperDefaultClass(t *testing.T) {
+	server := newTestServer(t, withOper(t))
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+	bob.Send("MODE #test +k key")
+	bob.Expect(` MODE #test \+k key$`)
+
+	// an operator without a class may do everything
+	alice.Oper()
+	bob.Send("WHOIS alice")
+	bob.Expect(`^:irc.test.net 313 bob alice :is an IRC operator$`)
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("KILL bob :bye")
+	bob.ExpectClosed()
+}
+
+// withRestrictedOper configures an operator "restricted", password
+// "secret", with the given restrictions.
+func withRestrictedOper(t *testing.T, restrict func(*OperConfig)) func(*Config) {
+	return func(config *Config) {
+		operConfig := &OperConfig{PassConfig: PassConfig{Password: testPassword(t, "secret")}}
+		restrict(operConfig)
+		config.Operator["restricted"] = operConfig
+	}
+}
+
+func TestOperRestrictions(t *testing.T) {
+	server := newTestServer(t, withOper(t), withRestrictedOper(t, func(config *OperConfig) {
+		config.Hosts = []string{"*@nowhere.example"}
+	}))
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	carol := server.Register("carol")
+	alice.Oper()
+	carol.Send("MODE carol +w")
+	carol.Expect(` MODE carol :?\+w$`)
+
+	bob.Send("OPER restricted secret")
+	bob.Expect(`^:irc.test.net 491 bob :No O-lines for your host$`)
+	alice.Expect(`^:irc.test.net NOTICE alice :Failed OPER attempt as restricted by bob!bob@\S+: host not allowed$`)
+	// +w alone doesn't show failed attempts
+	carol.ExpectNone(`Failed OPER attempt`)
+
+	// a wrong password gets the same reply, so it can't be guessed from here
+	bob.Send("OPER restricted wrong")
+	bob.Expect(`^:irc.test.net 491 bob :No O-lines for your host$`)
+	alice.Expect(`:Failed OPER attempt as restricted by bob!bob@\S+: host not allowed$`)
+
+	// the third attempt is the last one allowed for now
+	bob.Send("OPER oper wrong")
+	bob.Expect(`^:irc.test.net 464 bob `)
+	alice.Expect(`:Failed OPER attempt as oper by bob!bob@\S+: bad name or password$`)
+	bob.Send("OPER oper secret")
+	bob.Expect(`^:irc.test.net 263 bob OPER :Please wait a while and try again.$`)
+	alice.ExpectNone(`Failed OPER attempt`)
+}
+
+func TestOperRequireSecure(t *testing.T) {
+	server := newTestServer(t, withOper(t), withRestrictedOper(t, func(config *OperConfig) {
+		config.Hosts = []string{"bob!*@*"}
+		config.Secure = true
+	}))
+	bob := server.Register("bob")
+
+	bob.Send("OPER restricted secret")
+	bob.Expect(`^:irc.test.net 491 bob `)
+}
+
+func TestOperRequireAccount(t *testing.T) {
+	server := newTestServer(t, withOper(t), withRestrictedOper(t, func(config *OperConfig) {
+		config.Account = "bob"
+	}))
+	bob := server.Register("bob")
+
+	bob.Send("OPER restricted secret")
+	bob.Expect(`^:irc.test.net 491 bob `)
+}
+
+func TestNormalizeCertFP(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("abcd01", NormalizeCertFP("AB:CD:01"))
+	assert.Equal("abcd01", NormalizeCertFP("abcd01"))
+}
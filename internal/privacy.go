@@ -5,7 +5,7 @@ func CanSeeChannel(client *Client, channel *Channel) bool {
 	isSecret := channel.flags.Has(Secret)
 
 	isMember := channel.members.Has(client)
-	isOperator := client.modes.Has(Operator)
+	isOperator := client.HasPrivilege(PrivOverrideChannel)
 	isRegistered := client.modes.Has(Registered)
 	isSecure := client.modes.Has(SecureConn)
 
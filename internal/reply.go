@@ -2,6 +2,7 @@ package internal
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -75,12 +76,19 @@ func joinedLen(names []string) int {
 
 func (target *Client) MultilineReply(names []string, code NumericCode, format string,
 	args ...interface{}) {
+	target.MultilineReplyWithSep(names, " ", code, format, args...)
+}
+
+// MultilineReplyWithSep is MultilineReply for lists separated by sep, which
+// must be one byte long.
+func (target *Client) MultilineReplyWithSep(names []string, sep string, code NumericCode,
+	format string, args ...interface{}) {
 	baseLen := len(NewNumericReply(target, code, format))
 	tooLong := func(names []string) bool {
 		return (baseLen + joinedLen(names)) > MAX_REPLY_LEN
 	}
 	argsAndNames := func(names []string) []interface{} {
-		return append(args, strings.Join(names, " "))
+		return append(args, strings.Join(names, sep))
 	}
 	from, to := 0, 1
 	for to < len(names) {
@@ -112,6 +120,10 @@ func RplNotice(source Identifiable, target Identifiable, message Text) string {
 	return NewStringReply(source, NOTICE, "%s :%s", target.Nick(), message)
 }
 
+func RplTagMsg(source Identifiable, target Identifiable) string {
+	return NewStringReply(source, TAGMSG, "%s", target.Nick())
+}
+
 func RplNick(source Identifiable, newNick Name) string {
 	return NewStringReply(source, NICK, newNick.String())
 }
@@ -120,6 +132,37 @@ func RplJoin(client *Client, channel *Channel) string {
 	return NewStringReply(client, JOIN, channel.name.String())
 }
 
+func RplExtendedJoin(client *Client, channel *Channel) string {
+	account := client.Account()
+	if account == "" {
+		account = "*"
+	}
+	return NewStringReply(client, JOIN, "%s %s :%s",
+		channel.name, account, client.realname)
+}
+
+func RplAwayMsg(client *Client, message Text) string {
+	if message == "" {
+		return strings.TrimSuffix(NewStringReply(client, AWAY, ""), " ")
+	}
+	return NewStringReply(client, AWAY, ":%s", message)
+}
+
+func RplSetName(client *Client, realname Text) string {
+	return NewStringReply(client, SETNAME, ":%s", realname)
+}
+
+func RplAccount(client *Client, account string) string {
+	if account == "" {
+		account = "*"
+	}
+	return NewStringReply(client, ACCOUNT, account)
+}
+
+func RplChgHost(client *Client, username Name, hostname Name) string {
+	return NewStringReply(client, CHGHOST, "%s %s", username, hostname)
+}
+
 func RplPart(client *Client, channel *Channel, message Text) string {
 	return NewStringReply(client, PART, "%s :%s", channel, message)
 }
@@ -128,9 +171,9 @@ func RplModeChanges(client *Client, target *Client, changes ModeChanges) string
 	return NewStringReply(client, MODE, "%s :%s", target.Nick(), changes)
 }
 
-func RplChannelMode(client *Client, channel *Channel,
+func RplChannelMode(source Identifiable, channel *Channel,
 	changes ChannelModeChanges) string {
-	return NewStringReply(client, MODE, "%s %s", channel, changes)
+	return NewStringReply(source, MODE, "%s %s", channel, changes)
 }
 
 func RplTopicMsg(source Identifiable, channel *Channel) string {
@@ -175,6 +218,25 @@ func RplCap(client *Client, subCommand CapSubCommand, arg interface{}) string {
 	return NewStringReply(client.server, CAP, "%s %s :%s", client.Nick(), subCommand, arg)
 }
 
+// RplAck acknowledges a labeled command that had no other response.
+func RplAck(server *Server) string {
+	return fmt.Sprintf(":%s %s", server.Id(), ACK)
+}
+
+func RplBatchStart(source Identifiable, id string, batchType string, params ...string) string {
+	return NewStringReply(source, BATCH, "+%s %s",
+		id, strings.Join(append([]string{batchType}, params...), " "))
+}
+
+func RplBatchEnd(source Identifiable, id string) string {
+	return NewStringReply(source, BATCH, "-%s", id)
+}
+
+// RplCapMore is a CAP 302 reply that is continued on the next line.
+func RplCapMore(client *Client, subCommand CapSubCommand, arg interface{}) string {
+	return NewStringReply(client.server, CAP, "%s %s * :%s", client.Nick(), subCommand, arg)
+}
+
 // numeric replies
 
 func (target *Client) RplWelcome() {
@@ -271,7 +333,7 @@ func (target *Client) RplWhois(client *Client) {
 func (target *Client) RplWhoisUser(client *Client) {
 	var clientHost Name
 
-	if target.modes.Has(Operator) || !client.modes.Has(HostMask) {
+	if target.HasPrivilege(PrivSeeHosts) || !client.modes.Has(HostMask) {
 		clientHost = client.hostname
 	} else {
 		clientHost = client.hostmask
@@ -288,8 +350,13 @@ func (target *Client) RplWhoisUser(client *Client) {
 }
 
 func (target *Client) RplWhoisOperator(client *Client) {
+	if client.operClass == nil || client.operClass.Name == "" {
+		target.NumericReply(RPL_WHOISOPERATOR,
+			"%s :is an IRC operator", client.Nick())
+		return
+	}
 	target.NumericReply(RPL_WHOISOPERATOR,
-		"%s :is an IRC operator", client.Nick())
+		"%s :is an IRC operator (%s)", client.Nick(), client.operClass.Name)
 }
 
 func (target *Client) RplWhoisSecure(client *Client) {
@@ -342,18 +409,17 @@ func (target *Client) RplChannelModeIs(channel *Channel) {
 		"%s %s", channel, channel.ModeString(target))
 }
 
-// <channel> <user> <host> <server> <nick> ( "H" / "G" ) ["*"] [ ( "@" / "+" ) ]
-// :<hopcount> <real name>
-func (target *Client) RplWhoReply(channel *Channel, client *Client) {
-	var clientHost Name
-
-	if target.modes.Has(Operator) || !client.modes.Has(HostMask) {
-		clientHost = client.hostname
-	} else {
-		clientHost = client.hostmask
+// whoHost returns the host of client to show target.
+func (target *Client) whoHost(client *Client) Name {
+	if target.HasPrivilege(PrivSeeHosts) || !client.modes.Has(HostMask) {
+		return client.hostname
 	}
+	return client.hostmask
+}
 
-	channelName := "*"
+// whoFlags returns the WHO flags of client, a member of channel if it is
+// not nil: ( "H" / "G" ) ["*"] [ ( "@" / "+" ) ]
+func (target *Client) whoFlags(channel *Channel, client *Client) string {
 	flags := ""
 
 	if client.modes.Has(Away) {
@@ -366,7 +432,6 @@ func (target *Client) RplWhoReply(channel *Channel, client *Client) {
 	}
 
 	if channel != nil {
-		channelName = channel.name.String()
 		if target.capabilities[MultiPrefix] {
 			if channel.members.Get(client).Has(ChannelOperator) {
 				flags += "@"
@@ -382,27 +447,96 @@ func (target *Client) RplWhoReply(channel *Channel, client *Client) {
 			}
 		}
 	}
+	return flags
+}
+
+// <channel> <user> <host> <server> <nick> ( "H" / "G" ) ["*"] [ ( "@" / "+" ) ]
+// :<hopcount> <real name>
+func (target *Client) RplWhoReply(channel *Channel, client *Client) {
+	channelName := "*"
+	if channel != nil {
+		channelName = channel.name.String()
+	}
 	target.NumericReply(
 		RPL_WHOREPLY,
 		"%s %s %s %s %s %s :%d %s",
 		channelName,
 		client.username,
-		clientHost,
+		target.whoHost(client),
 		client.server.name,
 		client.Nick(),
-		flags,
+		target.whoFlags(channel, client),
 		client.hops,
 		client.realname,
 	)
 }
 
+// WhoxFields are the WHOX fields, in the order they are replied in.
+const WhoxFields = "tcuihsnfdlaor"
+
+// RplWhoxReply is the WHOX reply with the requested fields, in the order of
+// WhoxFields; the real name, if requested, is always last.
+func (target *Client) RplWhoxReply(channel *Channel, client *Client, fields string, token string) {
+	params := make([]string, 0, len(WhoxFields))
+	for _, field := range WhoxFields {
+		if !strings.ContainsRune(fields, field) {
+			continue
+		}
+		switch field {
+		case 't':
+			if token == "" {
+				continue
+			}
+			params = append(params, token)
+		case 'c':
+			if channel != nil {
+				params = append(params, channel.name.String())
+			} else {
+				params = append(params, "*")
+			}
+		case 'u':
+			params = append(params, client.username.String())
+		case 'i':
+			// I2P and Tor clients have no IP
+			if client.ip != nil && (target.HasPrivilege(PrivSeeHosts) || target == client) {
+				params = append(params, client.ip.String())
+			} else {
+				params = append(params, "255.255.255.255")
+			}
+		case 'h':
+			params = append(params, target.whoHost(client).String())
+		case 's':
+			params = append(params, client.server.name.String())
+		case 'n':
+			params = append(params, client.Nick().String())
+		case 'f':
+			params = append(params, target.whoFlags(channel, client))
+		case 'd':
+			params = append(params, strconv.FormatUint(uint64(client.hops), 10))
+		case 'l':
+			params = append(params, strconv.FormatUint(client.IdleSeconds(), 10))
+		case 'a':
+			if account := client.Account(); account != "" {
+				params = append(params, account)
+			} else {
+				params = append(params, "0")
+			}
+		case 'o':
+			params = append(params, "n/a")
+		case 'r':
+			params = append(params, ":"+client.realname.String())
+		}
+	}
+	target.NumericReply(RPL_WHOSPCRPL, "%s", strings.Join(params, " "))
+}
+
 // <name> :End of WHO list
 func (target *Client) RplEndOfWho(name Name) {
 	target.NumericReply(RPL_ENDOFWHO,
 		"%s :End of WHO list", name)
 }
 
-func (target *Client) RplMaskList(mode ChannelMode, channel *Channel, mask Name) {
+func (target *Client) RplMaskList(mode ChannelMode, channel *Channel, mask *UserMask) {
 	switch mode {
 	case BanMask:
 		target.RplBanList(channel, mask)
@@ -412,6 +546,9 @@ func (target *Client) RplMaskList(mode ChannelMode, channel *Channel, mask Name)
 
 	case InviteMask:
 		target.RplInviteList(channel, mask)
+
+	case QuietMask:
+		target.RplQuietList(channel, mask)
 	}
 }
 
@@ -425,12 +562,27 @@ func (target *Client) RplEndOfMaskList(mode ChannelMode, channel *Channel) {
 
 	case InviteMask:
 		target.RplEndOfInviteList(channel)
+
+	case QuietMask:
+		target.RplEndOfQuietList(channel)
 	}
 }
 
-func (target *Client) RplBanList(channel *Channel, mask Name) {
+// <channel> q <mask> <setter> <time> [ :<reason> ]
+func (target *Client) RplQuietList(channel *Channel, mask *UserMask) {
+	target.NumericReply(RPL_QUIETLIST,
+		"%s %s %s", channel, QuietMask, mask.listEntry())
+}
+
+func (target *Client) RplEndOfQuietList(channel *Channel) {
+	target.NumericReply(RPL_ENDOFQUIETLIST,
+		"%s %s :End of channel quiet list", channel, QuietMask)
+}
+
+// <channel> <mask> <setter> <time> [ :<reason> ]
+func (target *Client) RplBanList(channel *Channel, mask *UserMask) {
 	target.NumericReply(RPL_BANLIST,
-		"%s %s", channel, mask)
+		"%s %s", channel, mask.listEntry())
 }
 
 func (target *Client) RplEndOfBanList(channel *Channel) {
@@ -438,9 +590,9 @@ func (target *Client) RplEndOfBanList(channel *Channel) {
 		"%s :End of channel ban list", channel)
 }
 
-func (target *Client) RplExceptList(channel *Channel, mask Name) {
+func (target *Client) RplExceptList(channel *Channel, mask *UserMask) {
 	target.NumericReply(RPL_EXCEPTLIST,
-		"%s %s", channel, mask)
+		"%s %s", channel, mask.listEntry())
 }
 
 func (target *Client) RplEndOfExceptList(channel *Channel) {
@@ -448,9 +600,9 @@ func (target *Client) RplEndOfExceptList(channel *Channel) {
 		"%s :End of channel exception list", channel)
 }
 
-func (target *Client) RplInviteList(channel *Channel, mask Name) {
+func (target *Client) RplInviteList(channel *Channel, mask *UserMask) {
 	target.NumericReply(RPL_INVITELIST,
-		"%s %s", channel, mask)
+		"%s %s", channel, mask.listEntry())
 }
 
 func (target *Client) RplEndOfInviteList(channel *Channel) {
@@ -473,6 +625,42 @@ func (target *Client) RplAway(client *Client) {
 		"%s :%s", client.Nick(), client.awayMessage)
 }
 
+// RplISupport sends the server's RPL_ISUPPORT tokens, as many lines as
+// it takes.
+func (target *Client) RplISupport(tokens []string) {
+	const maxTokens = 13
+	for len(tokens) > 0 {
+		n := len(tokens)
+		if n > maxTokens {
+			n = maxTokens
+		}
+		target.NumericReply(RPL_ISUPPORT,
+			"%s :are supported by this server", strings.Join(tokens[:n], " "))
+		tokens = tokens[n:]
+	}
+}
+
+func (target *Client) RplMonOnline(targets []string) {
+	target.MultilineReplyWithSep(targets, ",", RPL_MONONLINE, ":%s")
+}
+
+func (target *Client) RplMonOffline(targets []string) {
+	target.MultilineReplyWithSep(targets, ",", RPL_MONOFFLINE, ":%s")
+}
+
+func (target *Client) RplMonList(targets []string) {
+	target.MultilineReplyWithSep(targets, ",", RPL_MONLIST, ":%s")
+}
+
+func (target *Client) RplEndOfMonList() {
+	target.NumericReply(RPL_ENDOFMONLIST, ":End of MONITOR list")
+}
+
+func (target *Client) ErrMonListFull(limit int, targets []string) {
+	target.NumericReply(ERR_MONLISTFULL,
+		"%d %s :Monitor list is full", limit, strings.Join(targets, ","))
+}
+
 func (target *Client) RplIsOn(nicks []string) {
 	target.NumericReply(RPL_ISON,
 		":%s", strings.Join(nicks, " "))
@@ -536,6 +724,116 @@ func (target *Client) RplInviting(invitee *Client, channel Name) {
 		"%s %s", invitee.Nick(), channel)
 }
 
+// <channel>
+func (target *Client) RplInvitedList(channel *Channel) {
+	target.NumericReply(RPL_INVITEDLIST,
+		"%s", channel)
+}
+
+func (target *Client) RplEndOfInvitedList() {
+	target.NumericReply(RPL_ENDOFINVITEDLIST,
+		":End of /INVITE list")
+}
+
+// <channel> <nick>!<user>@<host> :has asked for an invite
+func (target *Client) RplKnock(channel *Channel, knocker *Client, message Text) {
+	if message != "" {
+		target.NumericReply(RPL_KNOCK,
+			"%s %s :has asked for an invite (%s)", channel, knocker.UserHost(true), message)
+		return
+	}
+	target.NumericReply(RPL_KNOCK,
+		"%s %s :has asked for an invite", channel, knocker.UserHost(true))
+}
+
+func (target *Client) RplKnockDelivered(channel *Channel) {
+	target.NumericReply(RPL_KNOCKDLVR,
+		"%s :Your KNOCK has been delivered", channel)
+}
+
+func (target *Client) ErrTooManyKnock(channel *Channel) {
+	target.NumericReply(ERR_TOOMANYKNOCK,
+		"%s :Too many KNOCKs, try again later", channel)
+}
+
+func (target *Client) ErrChanOpen(channel *Channel) {
+	target.NumericReply(ERR_CHANOPEN,
+		"%s :Channel is open", channel)
+}
+
+func (target *Client) ErrKnockOnChan(channel *Channel) {
+	target.NumericReply(ERR_KNOCKONCHAN,
+		"%s :You're already on that channel", channel)
+}
+
+// SILENCE ( "+" / "-" ) <mask>
+func RplSilence(client *Client, op ModeOp, mask Name) string {
+	return NewStringReply(client, SILENCE, "%s%s", op, mask)
+}
+
+// <nick> <mask>
+func (target *Client) RplSileList(mask *UserMask) {
+	target.NumericReply(RPL_SILELIST,
+		"%s %s", target.Nick(), mask.mask)
+}
+
+func (target *Client) RplEndOfSileList() {
+	target.NumericReply(RPL_ENDOFSILELIST,
+		":End of Silence List")
+}
+
+func (target *Client) ErrSileListFull(mask Name) {
+	target.NumericReply(ERR_SILELISTFULL,
+		"%s :Your silence list is full", mask)
+}
+
+func (target *Client) RplAcceptList(nicks []string) {
+	target.MultilineReply(nicks, RPL_ACCEPTLIST, "%s")
+}
+
+func (target *Client) RplEndOfAccept() {
+	target.NumericReply(RPL_ENDOFACCEPT,
+		":End of /ACCEPT list")
+}
+
+func (target *Client) ErrAcceptFull() {
+	target.NumericReply(ERR_ACCEPTFULL,
+		":Accept list is full")
+}
+
+func (target *Client) ErrAcceptExist(nick Name) {
+	target.NumericReply(ERR_ACCEPTEXIST,
+		"%s :is already on your accept list", nick)
+}
+
+func (target *Client) ErrAcceptNot(nick Name) {
+	target.NumericReply(ERR_ACCEPTNOT,
+		"%s :is not on your accept list", nick)
+}
+
+func (target *Client) ErrNoNonReg(nick Name) {
+	target.NumericReply(ERR_NONONREG,
+		"%s :You must be logged into an account to message this user", nick)
+}
+
+// <nick> :is in +g mode (server-side ignore.)
+func (target *Client) RplTargUModeG(nick Name) {
+	target.NumericReply(RPL_TARGUMODEG,
+		"%s :is in +g mode (server-side ignore.)", nick)
+}
+
+func (target *Client) RplTargNotify(nick Name) {
+	target.NumericReply(RPL_TARGNOTIFY,
+		"%s :has been informed that you messaged them.", nick)
+}
+
+// <nick> <user>@<host> :is messaging you, and you have user mode +g set.
+func (target *Client) RplUModeGMsg(sender *Client) {
+	target.NumericReply(RPL_UMODEGMSG,
+		"%s %s@%s :is messaging you, and you have user mode +g set. Use /ACCEPT %s to allow.",
+		sender.Nick(), sender.Username(), sender.hostmask, sender.Nick())
+}
+
 func (target *Client) RplTime() {
 	target.NumericReply(RPL_TIME,
 		"%s :%s", target.server.name, time.Now().Format(time.RFC1123))
@@ -615,7 +913,7 @@ func (target *Client) RplLUserMe() {
 func (target *Client) RplWhoWasUser(whoWas *WhoWas) {
 	var whoWasHost Name
 
-	if target.modes.Has(Operator) {
+	if target.HasPrivilege(PrivSeeHosts) {
 		whoWasHost = whoWas.hostname
 	} else {
 		whoWasHost = whoWas.hostmask
@@ -636,6 +934,78 @@ func (target *Client) RplEndOfWhoWas(nickname Name) {
 		"%s :End of WHOWAS", nickname)
 }
 
+func (target *Client) RplStatsLinkInfo(client *Client) {
+	stats := client.socket.Stats()
+	target.NumericReply(RPL_STATSLINKINFO,
+		"%s %d %d %d %d %d :%d",
+		client.linkName(), client.sendQ.Len(),
+		stats.LinesWritten, stats.BytesWritten/1024,
+		stats.LinesRead, stats.BytesRead/1024,
+		uint64(time.Since(client.ctime).Seconds()))
+}
+
+func (target *Client) RplStatsCommands(code StringCode, count int) {
+	target.NumericReply(RPL_STATSCOMMANDS,
+		"%s %d", code, count)
+}
+
+func (target *Client) RplStatsCLine(addr string, transport Transport) {
+	target.NumericReply(RPL_STATSCLINE,
+		"C %s * %s", addr, transport)
+}
+
+// K <host> * <user> :<reason>
+func (target *Client) RplStatsKLine(ban *UserMask) {
+	_, userhost, _ := strings.Cut(ban.mask.String(), "!")
+	user, host, _ := strings.Cut(userhost, "@")
+	target.NumericReply(RPL_STATSKLINE,
+		"K %s * %s :%s", host, user, ban.reason)
+}
+
+func (target *Client) RplStatsUptime(uptime time.Duration) {
+	seconds := uint64(uptime.Seconds())
+	target.NumericReply(RPL_STATSUPTIME,
+		":Server Up %d days %d:%02d:%02d",
+		seconds/86400, seconds/3600%24, seconds/60%60, seconds%60)
+}
+
+func (target *Client) RplStatsOLine(name Name) {
+	target.NumericReply(RPL_STATSOLINE,
+		"O * * %s", name)
+}
+
+func (target *Client) RplEndOfStats(query string) {
+	target.NumericReply(RPL_ENDOFSTATS,
+		"%s :End of STATS report", query)
+}
+
+// RplTraceUnknown only uses the socket, as the readloop may still be
+// setting up an unregistered client.
+func (target *Client) RplTraceUnknown(client *Client) {
+	target.NumericReply(RPL_TRACEUNKNOWN,
+		"???? users %s", IPString(client.socket.conn.RemoteAddr()))
+}
+
+func (target *Client) RplTraceOperator(client *Client) {
+	target.NumericReply(RPL_TRACEOPERATOR,
+		"Oper %s %s", client.traceClass(), client.Nick())
+}
+
+func (target *Client) RplTraceUser(client *Client) {
+	target.NumericReply(RPL_TRACEUSER,
+		"User %s %s", client.traceClass(), client.Nick())
+}
+
+func (target *Client) RplTryAgain(code StringCode) {
+	target.NumericReply(RPL_TRYAGAIN,
+		"%s :Please wait a while and try again.", code)
+}
+
+func (target *Client) RplTraceEnd() {
+	target.NumericReply(RPL_TRACEEND,
+		"%s %s :End of TRACE", target.server.name, FullVersion())
+}
+
 //
 // errors (also numeric)
 //
@@ -650,6 +1020,20 @@ func (target *Client) ErrNickNameInUse(nick Name) {
 		"%s :Nickname is already in use", nick)
 }
 
+// ErrParse reports a line from the client that couldn't be parsed as
+// command.
+func (target *Client) ErrParse(command StringCode, err *ParseError) {
+	if !err.hasCommand {
+		target.NumericReply(err.numeric, ":%s", err.message)
+		return
+	}
+	target.NumericReply(err.numeric, "%s :%s", command, err.message)
+}
+
+func (target *Client) ErrNotRegistered() {
+	target.NumericReply(ERR_NOTREGISTERED, ":You have not registered")
+}
+
 func (target *Client) ErrUnknownCommand(code StringCode) {
 	target.NumericReply(ERR_UNKNOWNCOMMAND,
 		"%s :Unknown command", code)
@@ -699,6 +1083,10 @@ func (target *Client) ErrPasswdMismatch() {
 	target.NumericReply(ERR_PASSWDMISMATCH, ":Password incorrect")
 }
 
+func (target *Client) ErrNoOperHost() {
+	target.NumericReply(ERR_NOOPERHOST, ":No O-lines for your host")
+}
+
 func (target *Client) ErrNoChanModes(channel *Channel) {
 	target.NumericReply(ERR_NOCHANMODES,
 		"%s :Channel doesn't support modes", channel)
@@ -726,6 +1114,12 @@ func (target *Client) ErrCannotSendToChan(channel *Channel) {
 		"%s :Cannot send to channel", channel)
 }
 
+// <channel> :Cannot send to channel (+<mode>)
+func (target *Client) ErrCannotSendToChanMode(channel *Channel, mode ChannelMode) {
+	target.NumericReply(ERR_CANNOTSENDTOCHAN,
+		"%s :Cannot send to channel (+%s)", channel, mode)
+}
+
 func (target *Client) ErrCannotSendToUser(nick Name, reason string) {
 	target.NumericReply(
 		ERR_CANNOTSENDTOUSER,
@@ -749,11 +1143,22 @@ func (target *Client) ErrNoNicknameGiven() {
 	target.NumericReply(ERR_NONICKNAMEGIVEN, ":No nickname given")
 }
 
+func (target *Client) ErrYoureBannedCreep(reason Text) {
+	target.NumericReply(ERR_YOUREBANNEDCREEP,
+		":You are banned from this server (%s)", reason)
+}
+
 func (target *Client) ErrErroneusNickname(nick Name) {
 	target.NumericReply(ERR_ERRONEUSNICKNAME,
 		"%s :Erroneous nickname", nick)
 }
 
+// <target> <mode> <param> :<description>
+func (target *Client) ErrInvalidModeParam(channel *Channel, mode ChannelMode, param string, description string) {
+	target.NumericReply(ERR_INVALIDMODEPARAM,
+		"%s %s %s :%s", channel, mode, param, description)
+}
+
 func (target *Client) ErrUnknownMode(mode ChannelMode, channel *Channel) {
 	target.NumericReply(ERR_UNKNOWNMODE,
 		"%s :is unknown mode char to me for %s", mode, channel)
@@ -779,11 +1184,44 @@ func (target *Client) ErrInvalidCapCmd(subCommand CapSubCommand) {
 		"%s :Invalid CAP subcommand", subCommand)
 }
 
-func (target *Client) ErrBannedFromChan(channel *Channel) {
+func (target *Client) ErrNeedReggedNick(channel *Channel) {
+	target.NumericReply(ERR_NEEDREGGEDNICK,
+		"%s :Cannot join channel (+R) - you need to be logged into your account", channel)
+}
+
+// <channel> :Cannot join channel (+j)
+func (target *Client) ErrJoinThrottled(channel *Channel) {
+	target.NumericReply(ERR_UNAVAILRESOURCE,
+		"%s :Cannot join channel (+j) - too many joins, try again later", channel)
+}
+
+// <channel> <target> :Forwarding to another channel
+func (target *Client) ErrLinkChannel(channel *Channel, to Name) {
+	target.NumericReply(ERR_LINKCHANNEL,
+		"%s %s :Forwarding to another channel", channel, to)
+}
+
+func (target *Client) ErrNoNickChange(channel *Channel) {
+	target.NumericReply(ERR_NONICKCHANGE,
+		"%s :Cannot change nickname while on channel (+N)", channel)
+}
+
+func (target *Client) ErrBannedFromChan(channel *Channel, reason Text) {
+	if reason != "" {
+		target.NumericReply(ERR_BANNEDFROMCHAN,
+			"%s :Cannot join channel (+b) - %s", channel, reason)
+		return
+	}
 	target.NumericReply(ERR_BANNEDFROMCHAN,
 		"%s :Cannot join channel (+b)", channel)
 }
 
+// <channel> <mode> :Channel list is full
+func (target *Client) ErrBanListFull(channel *Channel, mode ChannelMode) {
+	target.NumericReply(ERR_BANLISTFULL,
+		"%s %s :Channel list is full", channel, mode)
+}
+
 func (target *Client) ErrInviteOnlyChan(channel *Channel) {
 	target.NumericReply(ERR_INVITEONLYCHAN,
 		"%s :Cannot join channel (+i)", channel)
@@ -51,7 +51,7 @@ func (s *SaslState) WriteString(data string) {
 	s.buffer.WriteString(data)
 }
 
-func (s SaslState) Len() int {
+func (s *SaslState) Len() int {
 	s.RLock()
 	defer s.RUnlock()
 
@@ -82,3 +82,17 @@ func (s *SaslState) Id() string {
 
 	return s.authcid
 }
+
+// parseSaslPlain splits a decoded PLAIN payload, or returns why it was
+// rejected.
+func parseSaslPlain(data []byte) (authcid, password, reason string) {
+	tokens := bytes.Split(data, []byte{'\000'})
+	if len(tokens) != 3 {
+		return "", "", "invalid authentication blob"
+	}
+	authcid = string(tokens[0])
+	if authzid := string(tokens[1]); authzid != "" && authzid != authcid {
+		return "", "", "authzid and authcid should be the same"
+	}
+	return authcid, string(tokens[2]), ""
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword returns password hashed the way config files store them.
+func testPassword(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(hash)
+}
+
+func newSaslTestServer(t *testing.T) *testServer {
+	return newTestServer(t, func(config *Config) {
+		config.Account = map[string]*AccountConfig{
+			"alice": {PassConfig: PassConfig{Password: testPassword(t, "secret")}},
+		}
+	})
+}
+
+func saslPlain(authcid, password string) string {
+	return base64.StdEncoding.EncodeToString(
+		[]byte(authcid + "\x00" + authcid + "\x00" + password),
+	)
+}
+
+func TestSaslPlain(t *testing.T) {
+	server := newSaslTestServer(t)
+
+	alice := server.Connect()
+	alice.Send("CAP LS")
+	alice.Expect(`^:irc.test.net CAP \* LS :.*\bsasl\b`)
+	alice.Send("CAP REQ :sasl")
+	alice.Expect(`^:irc.test.net CAP \* ACK :sasl$`)
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+
+	alice.Send("AUTHENTICATE PLAIN")
+	alice.Expect(`^:irc.test.net AUTHENTICATE \+$`)
+	alice.Send("AUTHENTICATE %s", saslPlain("alice", "secret"))
+	alice.Expect(`^:irc.test.net 900 alice alice!alice@\S+ alice `)
+	alice.Expect(`^:irc.test.net 903 alice `)
+
+	alice.Send("CAP END")
+	alice.Expect(`^:irc.test.net 001 alice `)
+
+	alice.Send("WHOIS alice")
+	alice.Expect(`^:irc.test.net 330 alice alice alice `)
+}
+
+func TestSaslPlainFailure(t *testing.T) {
+	server := newSaslTestServer(t)
+
+	alice := server.Connect()
+	alice.Send("CAP LS")
+	alice.Send("CAP REQ :sasl")
+	alice.Expect(`^:irc.test.net CAP \* ACK :sasl$`)
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+
+	alice.Send("AUTHENTICATE PLAIN")
+	alice.Expect(`^:irc.test.net AUTHENTICATE \+$`)
+	alice.Send("AUTHENTICATE %s", saslPlain("alice", "wrong"))
+	alice.Expect(`^:irc.test.net 904 alice `)
+
+	alice.Send("AUTHENTICATE PLAIN")
+	alice.Expect(`^:irc.test.net AUTHENTICATE \+$`)
+	alice.Send("AUTHENTICATE %s", saslPlain("mallory", "secret"))
+	alice.Expect(`^:irc.test.net 904 alice `)
+
+	// registration still completes without an account
+	alice.Send("CAP END")
+	alice.Expect(`^:irc.test.net 001 alice `)
+	alice.Send("WHOIS alice")
+	alice.ExpectNone(` 330 `)
+}
+
+func TestSaslAbort(t *testing.T) {
+	server := newSaslTestServer(t)
+
+	alice := server.Connect()
+	alice.Send("CAP LS")
+	alice.Send("CAP REQ :sasl")
+	alice.Expect(`^:irc.test.net CAP \* ACK :sasl$`)
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+
+	// an aborted exchange leaves nothing behind for the next one
+	alice.Send("AUTHENTICATE PLAIN")
+	alice.Expect(`^:irc.test.net AUTHENTICATE \+$`)
+	alice.Send("AUTHENTICATE %s", strings.Repeat("A", 400))
+	alice.Send("AUTHENTICATE *")
+	alice.Expect(`^:irc.test.net 906 alice `)
+
+	alice.Send("AUTHENTICATE PLAIN")
+	alice.Expect(`^:irc.test.net AUTHENTICATE \+$`)
+	alice.Send("AUTHENTICATE %s", saslPlain("alice", "secret"))
+	alice.Expect(`^:irc.test.net 903 alice `)
+}
+
+func TestSaslMetrics(t *testing.T) {
+	server := newSaslTestServer(t)
+
+	alice := server.Connect()
+	alice.Send("CAP LS")
+	alice.Send("CAP REQ :sasl")
+	alice.Expect(`^:irc.test.net CAP \* ACK :sasl$`)
+	for _, password := range []string{"wrong", "secret"} {
+		alice.Send("AUTHENTICATE PLAIN")
+		alice.Expect(`^:irc.test.net AUTHENTICATE \+$`)
+		alice.Send("AUTHENTICATE %s", saslPlain("alice", password))
+		alice.Expect(`^:irc.test.net 90[34] `)
+	}
+
+	sasl := server.metrics.CounterVec("sasl", "authentications")
+	assert.Equal(t, 1.0, testutil.ToFloat64(sasl.WithLabelValues("failure")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(sasl.WithLabelValues("success")))
+}
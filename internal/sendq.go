@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+)
+
+// DefaultSendQ is the per-client sendq limit in bytes when none is configured.
+const DefaultSendQ = 1 << 20
+
+var ErrSendQExceeded = errors.New("SendQ exceeded")
+
+// SendQueue is a client's bounded outbound queue. Producers never block on
+// it: once the queued bytes would exceed the limit the queue is discarded
+// and closed, and the client is expected to be disconnected.
+type SendQueue struct {
+	sync.Mutex
+	lines  []string
+	size   int
+	limit  int
+	closed bool
+	wake   chan bool
+}
+
+func NewSendQueue(limit int) *SendQueue {
+	return &SendQueue{
+		limit: limit,
+		wake:  make(chan bool, 1),
+	}
+}
+
+// Push queues line for writing. It returns ErrSendQExceeded the first time
+// the limit is hit; lines pushed to a closed queue are dropped.
+func (q *SendQueue) Push(line string) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	if q.limit > 0 && q.size+len(line) > q.limit {
+		q.lines = nil
+		q.size = 0
+		q.closed = true
+		q.signal()
+		return ErrSendQExceeded
+	}
+
+	q.lines = append(q.lines, line)
+	q.size += len(line)
+	q.signal()
+	return nil
+}
+
+// Pop waits for the next line. ok is false once the queue has been closed
+// and drained.
+func (q *SendQueue) Pop() (line string, ok bool) {
+	for {
+		q.Lock()
+		if len(q.lines) > 0 {
+			line, q.lines = q.lines[0], q.lines[1:]
+			q.size -= len(line)
+			q.Unlock()
+			return line, true
+		}
+		if q.closed {
+			q.Unlock()
+			return "", false
+		}
+		q.Unlock()
+
+		<-q.wake
+	}
+}
+
+// Close stops the queue accepting lines; whatever is queued is still
+// handed out by Pop.
+func (q *SendQueue) Close() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.closed = true
+	q.signal()
+}
+
+// Len returns the number of bytes waiting to be written.
+func (q *SendQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.size
+}
+
+func (q *SendQueue) signal() {
+	select {
+	case q.wake <- true:
+	default:
+	}
+}
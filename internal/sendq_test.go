@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendQueue(t *testing.T) {
+	assert := assert.New(t)
+
+	q := NewSendQueue(10)
+	assert.Nil(q.Push("hello"))
+	assert.Nil(q.Push("world"))
+	assert.Equal(10, q.Len())
+
+	line, ok := q.Pop()
+	assert.True(ok)
+	assert.Equal("hello", line)
+	assert.Equal(5, q.Len())
+
+	assert.Equal(ErrSendQExceeded, q.Push("too much"))
+	assert.Equal(0, q.Len())
+
+	// once exceeded the queue is closed: pushes are dropped
+	assert.Nil(q.Push("x"))
+	_, ok = q.Pop()
+	assert.False(ok)
+}
+
+func TestSendQueueClose(t *testing.T) {
+	assert := assert.New(t)
+
+	q := NewSendQueue(0)
+	assert.Nil(q.Push("last words"))
+	q.Close()
+
+	line, ok := q.Pop()
+	assert.True(ok)
+	assert.Equal("last words", line)
+
+	_, ok = q.Pop()
+	assert.False(ok)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/cretz/bine/torutil/ed25519"
 	"github.com/eyedeekay/i2pkeys"
 	"github.com/eyedeekay/sam3"
+	"github.com/goshuirc/irc-go/ircmatch"
 	"github.com/prometheus/common/log"
 )
 
@@ -41,32 +42,65 @@ type ChannelNameMap struct {
 	channels map[Name]*Channel
 }
 
+// incomingConn is a connection accepted by a listener, on its way to the
+// server goroutine.
+type incomingConn struct {
+	conn      net.Conn
+	transport Transport
+}
+
+// listenerInfo is a listener the server accepts clients on.
+type listenerInfo struct {
+	addr      string
+	transport Transport
+}
+
 type Counter struct {
 	sync.RWMutex
 	value int
 }
 
+// Server owns all client and channel state on a single goroutine, Run.
+// Client goroutines only read from and write to their connection: parsed
+// commands are handed to Run through the commands channel, and replies go
+// out through each client's non-blocking sendq. The few values that
+// client goroutines do read and that Rehash swaps (the cloaker and the
+// operator passwords) are guarded by the server's RWMutex.
 type Server struct {
-	config      *Config
-	metrics     *Metrics
-	channels    *ChannelNameMap
-	connections *Counter
-	clients     *ClientLookupSet
-	ctime       time.Time
-	idle        chan *Client
-	motdFile    string
-	name        Name
-	network     Name
-	description string
-	newConns    chan net.Conn
-	operators   map[Name][]byte
-	accounts    PasswordStore
-	password    []byte
-	signals     chan os.Signal
-	done        chan bool
-	whoWas      *WhoWasList
-	ids         map[string]*Identity
-	templates   map[string]string
+	sync.RWMutex
+	config       *Config
+	metrics      *Metrics
+	tracing      *Tracing
+	channels     *ChannelNameMap
+	capabilities CapabilitySet
+	cloaker      *Cloaker
+	connections  *Counter
+	conns        map[*Client]bool // every connection, registered or not
+	clients      *ClientLookupSet
+	monitors     *MonitorIndex
+	commands     chan Command
+	commandUsage map[StringCode]int
+	ctime        time.Time
+	idle         chan *Client
+	maskExpiry   chan maskExpiry
+	filters      *FilterList
+	bans         *UserMaskSet // server bans set by filters
+	motdFile     string
+	name         Name
+	network      Name
+	description  string
+	newConns     chan incomingConn
+	listeners    []listenerInfo
+	operators    map[Name]*Oper
+	accounts     PasswordStore
+	vhosts       map[string]Name // account vhosts from the config
+	operVHosts   map[string]Name // set with VHOST; "" clears a vhost
+	password     []byte
+	signals      chan os.Signal
+	done         chan bool
+	whoWas       *WhoWasList
+	ids          map[string]*Identity
+	templates    map[string]string
 }
 
 type Identity struct {
@@ -84,29 +118,51 @@ var (
 
 func NewServer(config *Config) *Server {
 	server := &Server{
-		config:      config,
-		metrics:     NewMetrics("eris"),
-		channels:    NewChannelNameMap(),
-		connections: &Counter{},
-		clients:     NewClientLookupSet(),
-		ctime:       time.Now(),
-		idle:        make(chan *Client),
-		motdFile:    config.Server.MOTD,
-		name:        NewName(config.Server.Name),
-		network:     NewName(config.Network.Name),
-		description: config.Server.Description,
-		newConns:    make(chan net.Conn),
-		operators:   config.Operators(),
-		accounts:    NewMemoryPasswordStore(config.Accounts(), PasswordStoreOpts{}),
-		signals:     make(chan os.Signal, len(SERVER_SIGNALS)),
-		done:        make(chan bool),
-		whoWas:      NewWhoWasList(100),
-		ids:         make(map[string]*Identity),
-		templates:   map[string]string{},
+		config:       config,
+		metrics:      NewMetrics("eris"),
+		channels:     NewChannelNameMap(),
+		capabilities: NewCapabilitySet(config.Capabilities.Disabled),
+		cloaker:      NewCloaker(config.Cloaking.Secrets, config.Cloaking.Suffix),
+		connections:  &Counter{},
+		conns:        make(map[*Client]bool),
+		clients:      NewClientLookupSet(),
+		monitors:     NewMonitorIndex(),
+		commands:     make(chan Command),
+		commandUsage: make(map[StringCode]int),
+		ctime:        time.Now(),
+		idle:         make(chan *Client),
+		maskExpiry:   make(chan maskExpiry),
+		bans:         NewUserMaskSet(),
+		motdFile:     config.Server.MOTD,
+		name:         NewName(config.Server.Name),
+		network:      NewName(config.Network.Name),
+		description:  config.Server.Description,
+		newConns:     make(chan incomingConn),
+		operators:    config.Operators(),
+		accounts:     NewMemoryPasswordStore(config.Accounts(), PasswordStoreOpts{}),
+		vhosts:       config.VHosts(),
+		operVHosts:   make(map[string]Name),
+		signals:      make(chan os.Signal, len(SERVER_SIGNALS)),
+		done:         make(chan bool),
+		whoWas:       NewWhoWasList(100),
+		ids:          make(map[string]*Identity),
+		templates:    map[string]string{},
 	}
 
 	log.Debugf("accounts: %v", config.Accounts())
 
+	tracing, err := NewTracing(config.Tracing, server.name)
+	if err != nil {
+		log.Fatalf("tracing setup error, %s", err)
+	}
+	server.tracing = tracing
+
+	filters, err := LoadFilterList(config.Server.FilterFile)
+	if err != nil {
+		log.Fatalf("filter file load error, %s", err)
+	}
+	server.filters = filters
+
 	// TODO: Make this configureable?
 	server.ids["global"] = NewIdentity(config.Server.Name, "global")
 
@@ -130,6 +186,15 @@ func NewServer(config *Config) *Server {
 		server.listentor(addr, torconfig)
 	}
 
+	// the WWW site, with the metrics mounted on it if asked for
+	var www http.Handler = server
+	if config.Metrics.WWW {
+		mux := http.NewServeMux()
+		server.metrics.Mount(mux, config.MetricsPath())
+		mux.Handle("/", server)
+		www = mux
+	}
+
 	server.templates["en"] = default_template
 	if len(config.WWW.Listen)+len(config.WWW.TLSListen)+len(config.WWW.I2PListen)+len(config.WWW.TorListen) >= 0 {
 		if config.TemplateDir != "" {
@@ -160,7 +225,7 @@ func NewServer(config *Config) *Server {
 			if err != nil {
 				log.Fatal("listen error: ", err)
 			}
-			go http.Serve(listener, server)
+			go http.Serve(listener, www)
 		}
 
 		for addr, tlsconfig := range config.WWW.TLSListen {
@@ -168,7 +233,7 @@ func NewServer(config *Config) *Server {
 			if err != nil {
 				log.Fatalf("HTTPS WWW site generation error, %s", err)
 			}
-			go http.Serve(tlslisten, server)
+			go http.Serve(tlslisten, www)
 		}
 
 		for addr, i2pconfig := range config.WWW.I2PListen {
@@ -176,7 +241,7 @@ func NewServer(config *Config) *Server {
 			if err != nil {
 				log.Fatalf("I2P WWW site generation error, %s", err)
 			}
-			go http.Serve(i2plisten, server)
+			go http.Serve(i2plisten, www)
 		}
 
 		for addr, torconfig := range config.WWW.TorListen {
@@ -184,7 +249,7 @@ func NewServer(config *Config) *Server {
 			if err != nil {
 				log.Fatalf("Tor WWW site generation error, %s", err)
 			}
-			go http.Serve(torlisten, server)
+			go http.Serve(torlisten, www)
 		}
 	}
 	signal.Notify(server.signals, SERVER_SIGNALS...)
@@ -257,7 +322,36 @@ func NewServer(config *Config) *Server {
 		"Client ping latency in seconds",
 	)
 
-	go server.metrics.Run(":9314")
+	// client commands counter (by command)
+	server.metrics.NewCounterVec(
+		"client", "command_calls",
+		"Number of client commands processed (by command)",
+		[]string{"command"},
+	)
+
+	// client sendq exceeded counter
+	server.metrics.NewCounter(
+		"client", "sendq_exceeded",
+		"Number of clients disconnected for exceeding their sendq",
+	)
+
+	// channel messages counter (by channel)
+	server.metrics.NewCounterVec(
+		"channel", "messages",
+		"Number of messages sent to a channel (by channel)",
+		[]string{"channel"},
+	)
+
+	// sasl authentication counter (by result)
+	server.metrics.NewCounterVec(
+		"sasl", "authentications",
+		"Number of SASL authentication attempts (by result)",
+		[]string{"result"},
+	)
+
+	if config.Metrics.Listen != "" {
+		go server.metrics.Run(config.Metrics.Listen, config.MetricsPath())
+	}
 
 	return server
 }
@@ -267,7 +361,7 @@ func (server *Server) Wallops(message string) {
 	server.clients.Range(func(_ Name, client *Client) bool {
 		if client.modes.Has(WallOps) {
 			server.metrics.Counter("client", "messages").Inc()
-			client.replies <- RplNotice(server, client, text)
+			client.Reply(RplNotice(server, client, text))
 		}
 		return true
 	})
@@ -277,11 +371,24 @@ func (server *Server) Wallopsf(format string, args ...interface{}) {
 	server.Wallops(fmt.Sprintf(format, args...))
 }
 
+// OperNoticef sends a notice to the operators holding privilege. Unlike
+// WALLOPS, it can't be had by setting +w, so it may name real hosts.
+func (server *Server) OperNoticef(privilege OperPrivilege, format string, args ...interface{}) {
+	text := NewText(fmt.Sprintf(format, args...))
+	server.clients.Range(func(_ Name, client *Client) bool {
+		if client.HasPrivilege(privilege) {
+			server.metrics.Counter("client", "messages").Inc()
+			client.Reply(RplNotice(server, client, text))
+		}
+		return true
+	})
+}
+
 func (server *Server) Global(message string) {
 	text := NewText(message)
 	server.clients.Range(func(_ Name, client *Client) bool {
 		server.metrics.Counter("client", "messages").Inc()
-		client.replies <- RplNotice(server.ids["global"], client, text)
+		client.Reply(RplNotice(server.ids["global"], client, text))
 		return true
 	})
 }
@@ -295,7 +402,8 @@ func (server *Server) Shutdown() {
 }
 
 func (server *Server) Stop() {
-	server.done <- true
+	close(server.done)
+	server.tracing.Shutdown()
 }
 
 func (server *Server) Run() {
@@ -311,16 +419,22 @@ func (server *Server) Run() {
 				server.Stop()
 			}()
 
-		case conn := <-server.newConns:
-			go NewClient(server, conn)
+		case incoming := <-server.newConns:
+			NewClient(server, incoming.conn, incoming.transport)
+
+		case cmd := <-server.commands:
+			cmd.Client().processCommand(cmd)
 
 		case client := <-server.idle:
 			client.Idle()
+
+		case expiry := <-server.maskExpiry:
+			expiry.channel.expireMask(expiry.mode, expiry.mask)
 		}
 	}
 }
 
-func (s *Server) acceptor(listener net.Listener) {
+func (s *Server) acceptor(listener net.Listener, transport Transport) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -328,16 +442,20 @@ func (s *Server) acceptor(listener net.Listener) {
 			continue
 		}
 		log.Debugf("%s accept: %s", s, conn.RemoteAddr())
+		s.accept(conn, transport)
+	}
+}
 
-		if _, ok := conn.(*tls.Conn); ok {
-			s.metrics.GaugeVec("server", "clients").WithLabelValues("secure").Inc()
-		} else {
-			s.metrics.GaugeVec("server", "clients").WithLabelValues("insecure").Inc()
-		}
-
-		s.connections.Inc()
-		s.newConns <- conn
+// accept hands a new connection to the server goroutine.
+func (s *Server) accept(conn net.Conn, transport Transport) {
+	if _, ok := conn.(*tls.Conn); ok {
+		s.metrics.GaugeVec("server", "clients").WithLabelValues("secure").Inc()
+	} else {
+		s.metrics.GaugeVec("server", "clients").WithLabelValues("insecure").Inc()
 	}
+
+	s.connections.Inc()
+	s.newConns <- incomingConn{conn, transport}
 }
 
 //
@@ -352,7 +470,8 @@ func (s *Server) listen(addr string) {
 
 	log.Infof("%s listening on %s", s, addr)
 
-	go s.acceptor(listener)
+	s.listeners = append(s.listeners, listenerInfo{addr, TransportTCP})
+	go s.acceptor(listener, TransportTCP)
 }
 
 func (s *Server) tlslistener(addr string, tlsconfig *TLSConfig) (net.Listener, error) {
@@ -360,7 +479,12 @@ func (s *Server) tlslistener(addr string, tlsconfig *TLSConfig) (net.Listener, e
 	if err != nil {
 		log.Fatalf("error loading tls cert/key pair: %s", err)
 	}
-	config := tls.Config{Certificates: []tls.Certificate{cert}}
+	config := tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// ask for a client certificate, for its fingerprint; it's not
+		// verified against any CA
+		ClientAuth: tls.RequestClientCert,
+	}
 	config.Rand = rand.Reader
 	return tls.Listen("tcp", addr, &config)
 }
@@ -377,7 +501,8 @@ func (s *Server) listentls(addr string, tlsconfig *TLSConfig) {
 
 	log.Infof("%s listening on %s (TLS)", s, addr)
 
-	go s.acceptor(listener)
+	s.listeners = append(s.listeners, listenerInfo{addr, TransportTLS})
+	go s.acceptor(listener, TransportTLS)
 }
 
 //
@@ -436,7 +561,8 @@ func (s *Server) listeni2p(addr string, i2pconfig *I2PConfig) {
 		log.Fatalf("error binding to %s: %s", listener.Addr().(i2pkeys.I2PAddr).Base32(), err)
 	}
 	log.Infof("Listening on I2P address, %s", listener.Addr().(i2pkeys.I2PAddr).Base32())
-	go s.acceptor(listener)
+	s.listeners = append(s.listeners, listenerInfo{listener.Addr().(i2pkeys.I2PAddr).Base32(), TransportI2P})
+	go s.acceptor(listener, TransportI2P)
 }
 
 func (s *Server) torlistener(addr string, torconfig *TorConfig) (net.Listener, error) {
@@ -502,7 +628,8 @@ func (s *Server) listentor(addr string, torconfig *TorConfig) {
 		log.Fatalf("Unable to create onion service: %v", err)
 	}
 	log.Infof("Listening on Onion address, %s", torconfig.Onion)
-	go s.acceptor(listener)
+	s.listeners = append(s.listeners, listenerInfo{torconfig.Onion, TransportTor})
+	go s.acceptor(listener, TransportTor)
 }
 
 //
@@ -515,11 +642,19 @@ func (s *Server) tryRegister(c *Client) {
 		return
 	}
 
+	if ban := s.bans.Find(c); ban != nil {
+		c.ErrYoureBannedCreep(ban.reason)
+		c.Quit("Banned: " + ban.reason)
+		return
+	}
+
 	c.Register()
+	c.monitorOnline()
 	c.RplWelcome()
 	c.RplYourHost()
 	c.RplCreated()
 	c.RplMyInfo()
+	c.RplISupport(s.ISupport())
 
 	lusers := LUsersCommand{}
 	lusers.SetClient(c)
@@ -528,6 +663,23 @@ func (s *Server) tryRegister(c *Client) {
 	s.MOTD(c)
 }
 
+// ISupport returns the RPL_ISUPPORT tokens describing the server.
+func (server *Server) ISupport() []string {
+	return []string{
+		fmt.Sprintf("ACCEPT=%d", server.config.AcceptLimit()),
+		"CALLERID=" + CallerID.String(),
+		ChanModesToken(),
+		ExtBanToken(),
+		"KNOCK",
+		MaxListToken(server.config.MaxList()),
+		fmt.Sprintf("MONITOR=%d", server.config.MonitorLimit()),
+		fmt.Sprintf("NETWORK=%s", server.network),
+		"PREFIX=(ov)@+",
+		fmt.Sprintf("SILENCE=%d", server.config.SilenceLimit()),
+		"WHOX",
+	}
+}
+
 func (server *Server) MOTD(client *Client) {
 	if server.motdFile == "" {
 		client.ErrNoMOTD()
@@ -565,11 +717,49 @@ func (s *Server) Rehash() error {
 	s.name = NewName(s.config.Server.Name)
 	s.network = NewName(s.config.Network.Name)
 	s.description = s.config.Server.Description
+	s.vhosts = s.config.VHosts()
+	s.updateCapabilities(NewCapabilitySet(s.config.Capabilities.Disabled))
+
+	s.Lock()
 	s.operators = s.config.Operators()
 
+	// Only new connections pick up a rotated secret; previous secrets
+	// listed after it keep matching existing bans.
+	s.cloaker = s.cloaker.Rekey(s.config.Cloaking.Secrets, s.config.Cloaking.Suffix)
+	s.Unlock()
+
+	// Without a filter file, the filters added with FILTER ADD are all
+	// there is. A broken one leaves the old filters in place, but the
+	// rest of the config has been applied by now.
+	if s.config.Server.FilterFile == "" {
+		return nil
+	}
+	filters, err := LoadFilterList(s.config.Server.FilterFile)
+	if err != nil {
+		return err
+	}
+	s.filters = filters
+
 	return nil
 }
 
+// Cloaker returns the current cloaker. Safe to call from client goroutines.
+func (s *Server) Cloaker() *Cloaker {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.cloaker
+}
+
+// Oper returns the operator block called name, or nil. Safe to call from
+// client goroutines.
+func (s *Server) Oper(name Name) *Oper {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.operators[name]
+}
+
 func (s *Server) Id() Name {
 	return s.name
 }
@@ -644,8 +834,17 @@ func (msg *AuthenticateCommand) HandleRegServer(server *Server) {
 		return
 	}
 
+	sasl := server.metrics.CounterVec("sasl", "authentications")
+	fail := func(reason string) {
+		sasl.WithLabelValues("failure").Inc()
+		client.ErrSaslFail(reason)
+		client.sasl.Reset()
+	}
+
 	if msg.arg == "*" {
+		sasl.WithLabelValues("aborted").Inc()
 		client.ErrSaslAborted()
+		client.sasl.Reset()
 		return
 	}
 
@@ -655,7 +854,7 @@ func (msg *AuthenticateCommand) HandleRegServer(server *Server) {
 			client.Reply(RplAuthenticate(client, "+"))
 		} else {
 			client.RplSaslMechs("PLAIN")
-			client.ErrSaslFail("Unknown authentication mechanism")
+			fail("Unknown authentication mechanism")
 		}
 		return
 	}
@@ -676,43 +875,29 @@ func (msg *AuthenticateCommand) HandleRegServer(server *Server) {
 
 	data, err := base64.StdEncoding.DecodeString(client.sasl.String())
 	if err != nil {
-		client.ErrSaslFail("Invalid base64 encoding")
-		client.sasl.Reset()
+		fail("Invalid base64 encoding")
 		return
 	}
 
-	// Do authentication
-
-	var (
-		authcid  string
-		authzid  string
-		password string
-	)
-
-	tokens := bytes.Split(data, []byte{'\000'})
-	if len(tokens) == 3 {
-		authcid = string(tokens[0])
-		authzid = string(tokens[1])
-		password = string(tokens[2])
-
-		if authzid == "" {
-			authzid = authcid
-		} else if authzid != authcid {
-			client.ErrSaslFail("authzid and authcid should be the same")
-			return
-		}
-	} else {
-		client.ErrSaslFail("invalid authentication blob")
+	authcid, _, reason := parseSaslPlain(data)
+	if reason != "" {
+		fail(reason)
 		return
 	}
 
-	err = server.accounts.Verify(authcid, password)
-	if err != nil {
-		client.ErrSaslFail("invalid authentication")
+	// the password was checked on the client's readloop; its result only
+	// counts if the readloop saw the same payload
+	if !bytes.Equal(msg.plain, data) || msg.err != nil {
+		fail("invalid authentication")
 		return
 	}
 
+	sasl.WithLabelValues("success").Inc()
 	client.sasl.Login(authcid)
+	client.notifyFriends(AccountNotify, RplAccount(client, authcid))
+	if vhost := server.AccountVHost(authcid); vhost != "" {
+		client.SetVHost(vhost)
+	}
 	client.RplLoggedIn(authcid)
 	client.RplSaslSuccess()
 
@@ -741,6 +926,10 @@ func (msg *QuitCommand) HandleRegServer(server *Server) {
 	msg.Client().Quit(msg.message)
 }
 
+func (msg *ParseErrorCommand) HandleRegServer(server *Server) {
+	msg.HandleServer(server)
+}
+
 //
 // normal commands
 //
@@ -749,6 +938,10 @@ func (m *PassCommand) HandleServer(s *Server) {
 	m.Client().ErrAlreadyRegistered()
 }
 
+func (msg *ParseErrorCommand) HandleServer(server *Server) {
+	msg.Client().ErrParse(msg.Code(), msg.err)
+}
+
 func (m *PingCommand) HandleServer(s *Server) {
 	client := m.Client()
 	client.Reply(RplPong(client, m.server.Text()))
@@ -764,17 +957,21 @@ func (m *UserCommand) HandleServer(s *Server) {
 }
 
 func (msg *QuitCommand) HandleServer(server *Server) {
-	msg.Client().Quit(msg.message)
+	client := msg.Client()
+	message := msg.message
+	if message != "" && !client.Filter(FilterQuit, "", message) {
+		message = ""
+	}
+	client.Quit(message)
 }
 
 func (m *JoinCommand) HandleServer(s *Server) {
 	client := m.Client()
 
 	if m.zero {
-		client.channels.Range(func(channel *Channel) bool {
+		for _, channel := range client.Channels() {
 			channel.Part(client, client.Nick().Text())
-			return true
-		})
+		}
 		return
 	}
 
@@ -794,6 +991,10 @@ func (m *JoinCommand) HandleServer(s *Server) {
 
 func (m *PartCommand) HandleServer(server *Server) {
 	client := m.Client()
+	message := m.Message()
+	if m.message != "" && !client.Filter(FilterPart, "", m.message) {
+		message = client.Nick().Text()
+	}
 	for _, chname := range m.channels {
 		channel := server.channels.Get(chname)
 
@@ -802,7 +1003,7 @@ func (m *PartCommand) HandleServer(server *Server) {
 			continue
 		}
 
-		channel.Part(client, m.Message())
+		channel.Part(client, message)
 	}
 }
 
@@ -815,6 +1016,9 @@ func (msg *TopicCommand) HandleServer(server *Server) {
 	}
 
 	if msg.setTopic {
+		if !client.Filter(FilterChannel, channel.name, msg.topic) {
+			return
+		}
 		channel.SetTopic(client, msg.topic)
 	} else {
 		channel.GetTopic(client)
@@ -823,6 +1027,11 @@ func (msg *TopicCommand) HandleServer(server *Server) {
 
 func (msg *PrivMsgCommand) HandleServer(server *Server) {
 	client := msg.Client()
+	if ref, ok := msg.Tags()["batch"]; ok {
+		client.addToMultiline(ref, PRIVMSG, msg.target, msg.message, msg.Tags())
+		return
+	}
+
 	if msg.target.IsChannel() {
 		channel := server.channels.Get(msg.target)
 		if channel == nil {
@@ -830,7 +1039,10 @@ func (msg *PrivMsgCommand) HandleServer(server *Server) {
 			return
 		}
 
-		channel.PrivMsg(client, msg.message)
+		if !client.Filter(FilterChannel, channel.name, msg.message) {
+			return
+		}
+		channel.PrivMsg(client, msg.message, client.MessageTags(msg.Tags()))
 		return
 	}
 
@@ -839,17 +1051,64 @@ func (msg *PrivMsgCommand) HandleServer(server *Server) {
 		client.ErrNoSuchNick(msg.target)
 		return
 	}
+	if !client.Filter(FilterPrivate, target.nick, msg.message) {
+		return
+	}
 	if !client.CanSpeak(target) {
 		client.ErrCannotSendToUser(target.nick, "secure connection required")
 		return
 	}
+	if !client.CanMessage(target, PRIVMSG) {
+		return
+	}
 	server.metrics.Counter("client", "messages").Inc()
-	target.Reply(RplPrivMsg(client, target, msg.message))
+	replies := messageReplies(PRIVMSG, client, target, msg.message)
+	tags := client.MessageTags(msg.Tags())
+	target.ReplyLinesWithTags(replies, tags)
+	if client.capabilities[EchoMessage] {
+		client.ReplyLinesWithTags(replies, tags)
+	}
+	client.acceptReplies(target)
 	if target.modes.Has(Away) {
 		client.RplAway(target)
 	}
 }
 
+func (msg *TagMsgCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	tags := client.MessageTags(msg.Tags())
+	if msg.target.IsChannel() {
+		channel := server.channels.Get(msg.target)
+		if channel == nil {
+			client.ErrNoSuchChannel(msg.target)
+			return
+		}
+
+		channel.TagMsg(client, tags)
+		return
+	}
+
+	target := server.clients.Get(msg.target)
+	if target == nil {
+		client.ErrNoSuchNick(msg.target)
+		return
+	}
+	if !client.CanSpeak(target) {
+		client.ErrCannotSendToUser(target.nick, "secure connection required")
+		return
+	}
+	if !client.CanMessage(target, TAGMSG) {
+		return
+	}
+	reply := RplTagMsg(client, target)
+	if target.capabilities[MessageTags] {
+		target.ReplyWithTags(reply, tags)
+	}
+	if client.capabilities[EchoMessage] && client.capabilities[MessageTags] {
+		client.ReplyWithTags(reply, tags)
+	}
+}
+
 func (client *Client) WhoisChannelsNames(target *Client) []string {
 	chstrs := make([]string, client.channels.Count())
 	index := 0
@@ -892,10 +1151,32 @@ func (m *WhoisCommand) HandleServer(server *Server) {
 	}
 }
 
-func whoChannel(client *Client, channel *Channel, friends *ClientSet) {
+// whoVisible reports whether client can see member in WHO replies: other
+// users only show up when they are not invisible or share a channel with
+// the client, unless the client is an operator that may see hosts.
+func whoVisible(client *Client, member *Client, friends *ClientSet) bool {
+	return client.HasPrivilege(PrivSeeHosts) || !member.modes.Has(Invisible) || friends.Has(member)
+}
+
+func (msg *WhoCommand) reply(client *Client, channel *Channel, member *Client) {
+	if msg.operatorOnly && !member.modes.Has(Operator) {
+		return
+	}
+	if msg.whox {
+		client.RplWhoxReply(channel, member, msg.fields, msg.token)
+	} else {
+		client.RplWhoReply(channel, member)
+	}
+}
+
+func (msg *WhoCommand) whoChannel(client *Client, channel *Channel, friends *ClientSet) {
+	isMember := channel.members.Has(client)
+	if !isMember && !CanSeeChannel(client, channel) {
+		return
+	}
 	channel.members.Range(func(member *Client, _ *ChannelModeSet) bool {
-		if !client.modes.Has(Invisible) || friends.Has(client) {
-			client.RplWhoReply(channel, member)
+		if isMember || whoVisible(client, member, friends) {
+			msg.reply(client, channel, member)
 		}
 		return true
 	})
@@ -906,36 +1187,68 @@ func (msg *WhoCommand) HandleServer(server *Server) {
 	friends := client.Friends()
 	mask := msg.mask
 
-	if mask == "" {
-		server.channels.Range(func(name Name, channel *Channel) bool {
-			whoChannel(client, channel, friends)
+	switch {
+	case mask == "" || mask == "0" || mask == "*":
+		server.clients.Range(func(_ Name, member *Client) bool {
+			if member.registered && whoVisible(client, member, friends) {
+				msg.reply(client, nil, member)
+			}
 			return true
 		})
-	} else if mask.IsChannel() {
-		// TODO implement wildcard matching
-		channel := server.channels.Get(mask)
-		if channel != nil {
-			whoChannel(client, channel, friends)
+
+	case mask.IsChannelMask():
+		if channel := server.channels.Get(mask); channel != nil {
+			msg.whoChannel(client, channel, friends)
+			break
 		}
-	} else {
-		matches := server.clients.FindAll(mask)
-		matches.Range(func(mclient *Client) bool {
-			client.RplWhoReply(nil, mclient)
+		matcher := ircmatch.MakeMatch(mask.ToLower().String())
+		server.channels.Range(func(name Name, channel *Channel) bool {
+			if matcher.Match(name.ToLower().String()) {
+				msg.whoChannel(client, channel, friends)
+			}
+			return true
+		})
+
+	default:
+		server.clients.FindAll(mask).Range(func(member *Client) bool {
+			if member.registered && whoVisible(client, member, friends) {
+				msg.reply(client, nil, member)
+			}
 			return true
 		})
 	}
 
+	if mask == "" {
+		mask = "*"
+	}
 	client.RplEndOfWho(mask)
 }
 
 func (msg *OperCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
+	if !client.operThrottle.Allow(time.Now()) {
+		client.RplTryAgain(msg.Code())
+		return
+	}
+
+	// check the restrictions before the password, so that a client that
+	// may not log in at all can't tell a right password from a wrong one
+	if msg.oper != nil {
+		if err := msg.oper.CheckLogin(client); err != nil {
+			server.operFailed(client, msg.name, err.Error())
+			client.ErrNoOperHost()
+			return
+		}
+	}
+
 	if (msg.hash == nil) || (msg.err != nil) {
+		server.operFailed(client, msg.name, "bad name or password")
 		client.ErrPasswdMismatch()
 		return
 	}
 
+	client.operClass = msg.oper.class
 	client.modes.Set(Operator)
 	client.modes.Set(WallOps)
 	client.RplYoureOper()
@@ -950,9 +1263,16 @@ func (msg *OperCommand) HandleServer(server *Server) {
 	)
 }
 
+// operFailed tells the operators who may see hosts about a failed OPER
+// attempt.
+func (server *Server) operFailed(client *Client, name Name, reason string) {
+	server.OperNoticef(PrivSeeHosts, "Failed OPER attempt as %s by %s: %s",
+		name, client.UserHost(false), reason)
+}
+
 func (msg *RehashCommand) HandleServer(server *Server) {
 	client := msg.Client()
-	if !client.modes.Has(Operator) {
+	if !client.HasPrivilege(PrivRehash) {
 		client.ErrNoPrivileges()
 		return
 	}
@@ -978,10 +1298,28 @@ func (msg *AwayCommand) HandleServer(server *Server) {
 	client := msg.Client()
 	if len(msg.text) > 0 {
 		client.modes.Set(Away)
+		client.RplNowAway()
 	} else {
 		client.modes.Unset(Away)
+		client.RplUnAway()
 	}
 	client.awayMessage = msg.text
+	client.notifyFriends(AwayNotify, RplAwayMsg(client, msg.text))
+}
+
+func (msg *SetNameCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if msg.realname == "" || len(msg.realname) > MaxRealnameLen {
+		client.StandardReply(FailSetNameInvalid)
+		return
+	}
+
+	client.realname = msg.realname
+	reply := RplSetName(client, msg.realname)
+	if client.capabilities[SetName] {
+		client.ReplyWithTags(reply, client.Tags())
+	}
+	client.notifyFriends(SetName, reply)
 }
 
 func (msg *IsOnCommand) HandleServer(server *Server) {
@@ -1003,8 +1341,12 @@ func (msg *MOTDCommand) HandleServer(server *Server) {
 
 func (msg *NoticeCommand) HandleServer(server *Server) {
 	client := msg.Client()
+	if ref, ok := msg.Tags()["batch"]; ok {
+		client.addToMultiline(ref, NOTICE, msg.target, msg.message, msg.Tags())
+		return
+	}
 
-	if msg.target == "*" && client.modes.Has(Operator) {
+	if msg.target == "*" && client.HasPrivilege(PrivGlobalNotice) {
 		server.Global(msg.message.String())
 		return
 	}
@@ -1016,7 +1358,10 @@ func (msg *NoticeCommand) HandleServer(server *Server) {
 			return
 		}
 
-		channel.Notice(client, msg.message)
+		if !client.Filter(FilterChannel, channel.name, msg.message) {
+			return
+		}
+		channel.Notice(client, msg.message, client.MessageTags(msg.Tags()))
 		return
 	}
 
@@ -1026,12 +1371,23 @@ func (msg *NoticeCommand) HandleServer(server *Server) {
 		return
 	}
 
+	if !client.Filter(FilterPrivate, target.nick, msg.message) {
+		return
+	}
 	if !client.CanSpeak(target) {
 		client.ErrCannotSendToUser(target.nick, "secure connection required")
 		return
 	}
+	if !client.CanMessage(target, NOTICE) {
+		return
+	}
 	server.metrics.Counter("client", "messages").Inc()
-	target.Reply(RplNotice(client, target, msg.message))
+	replies := messageReplies(NOTICE, client, target, msg.message)
+	tags := client.MessageTags(msg.Tags())
+	target.ReplyLinesWithTags(replies, tags)
+	if client.capabilities[EchoMessage] {
+		client.ReplyLinesWithTags(replies, tags)
+	}
 }
 
 func (msg *KickCommand) HandleServer(server *Server) {
@@ -1116,6 +1472,11 @@ func (msg *VersionCommand) HandleServer(server *Server) {
 func (msg *InviteCommand) HandleServer(server *Server) {
 	client := msg.Client()
 
+	if msg.nickname == "" {
+		client.listInvites()
+		return
+	}
+
 	target := server.clients.Get(msg.nickname)
 	if target == nil {
 		client.ErrNoSuchNick(msg.nickname)
@@ -1163,7 +1524,7 @@ func (msg *WallopsCommand) HandleServer(server *Server) {
 
 func (msg *KillCommand) HandleServer(server *Server) {
 	client := msg.Client()
-	if !client.modes.Has(Operator) {
+	if !client.HasPrivilege(PrivKill) {
 		client.ErrNoPrivileges()
 		return
 	}
@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistration(t *testing.T) {
+	server := newTestServer(t)
+
+	alice := server.Connect()
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Expect(`^:irc.test.net 001 alice :Welcome to the TestNet Internet Relay Network alice!alice@\S+$`)
+	alice.Expect(`^:irc.test.net 002 alice `)
+	alice.Expect(`^:irc.test.net 003 alice `)
+	alice.Expect(`^:irc.test.net 004 alice irc.test.net `)
+	alice.Expect(`^:irc.test.net 422 alice `)
+}
+
+func TestRegistrationNickInUse(t *testing.T) {
+	server := newTestServer(t)
+	server.Register("alice")
+
+	other := server.Connect()
+	other.Send("NICK alice")
+	other.Expect(`^:irc.test.net 433 \* alice `)
+	other.Send("NICK alice2")
+	other.Send("USER alice 0 * :Alice")
+	other.Expect(`^:irc.test.net 001 alice2 `)
+}
+
+func TestRegistrationPassword(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		// bcrypt("secret"), base64 encoded
+		config.Server.Password = testPassword(t, "secret")
+	})
+
+	wrong := server.Connect()
+	wrong.Send("PASS wrong")
+	wrong.Expect(`^:irc.test.net 464 `)
+	wrong.ExpectClosed()
+
+	right := server.Connect()
+	right.Send("PASS secret")
+	right.Send("NICK alice")
+	right.Send("USER alice 0 * :Alice")
+	right.Expect(`^:irc.test.net 001 alice `)
+}
+
+func TestWho(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+
+	// members come back in no particular order
+	alice.Send("WHO #test")
+	who := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		match := alice.Expect(`^:irc.test.net 352 alice #test (\S+) \S+ irc.test.net \S+ (H@?) :0 `)
+		who[match[1]] = match[2]
+	}
+	assert.Equal(map[string]string{"alice": "H@", "bob": "H"}, who)
+	alice.Expect(`^:irc.test.net 315 alice #test `)
+
+	alice.Send("WHO bob")
+	alice.Expect(`^:irc.test.net 352 alice \S+ bob \S+ irc.test.net bob H`)
+	alice.Expect(`^:irc.test.net 315 alice bob `)
+}
+
+func TestWhois(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+
+	alice.Send("WHOIS bob")
+	alice.Expect(`^:irc.test.net 311 alice bob bob \S+ \* :bob$`)
+	alice.Expect(`^:irc.test.net 319 alice bob :@#test$`)
+	alice.Expect(`^:irc.test.net 318 alice bob `)
+
+	alice.Send("WHOIS nobody")
+	alice.Expect(`^:irc.test.net 401 alice nobody `)
+}
+
+func TestQuit(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	alice.Expect(`^:bob!\S+ JOIN #test$`)
+
+	bob.Send("QUIT :bye")
+	bob.ExpectClosed()
+	alice.Expect(`^:bob!\S+ QUIT :bye$`)
+
+	alice.Send("WHOIS bob")
+	alice.Expect(`^:irc.test.net 401 alice bob `)
+
+	// nothing sent after QUIT is handled
+	carol := server.Register("carol")
+	carol.Send("QUIT :bye\r\nNICK ghost\r\nJOIN #test")
+	carol.ExpectClosed()
+	alice.Send("WHOIS ghost")
+	alice.Expect(`^:irc.test.net 401 alice ghost `)
+	alice.Send("NAMES #test")
+	alice.Expect(`^:irc.test.net 353 alice = #test :@alice$`)
+}
+
+func TestParseErrors(t *testing.T) {
+	server := newTestServer(t)
+
+	alice := server.Connect()
+	alice.Send("FOO bar")
+	alice.Expect(`^:irc.test.net 421 \* FOO :Unknown command$`)
+	alice.Send("PRIVMSG bob :hi")
+	alice.Expect(`^:irc.test.net 451 \* :You have not registered$`)
+	alice.Send("NICK")
+	alice.Expect(`^:irc.test.net 431 \* :No nickname given$`)
+	alice.Send("USER alice")
+	alice.Expect(`^:irc.test.net 461 \* USER :Not enough parameters$`)
+
+	// extra parameters are ignored
+	alice.Send("NICK alice extra")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Expect(`^:irc.test.net 001 alice `)
+
+	alice.Send("PRIVMSG")
+	alice.Expect(`^:irc.test.net 461 alice PRIVMSG :Not enough parameters$`)
+	alice.Send("MODE alice x")
+	alice.Expect(`^:irc.test.net 400 alice MODE :Failed to parse message$`)
+	alice.Send("FOO")
+	alice.Expect(`^:irc.test.net 421 alice FOO :Unknown command$`)
+}
+
+func TestWhoInvisible(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t, withOper(t))
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	carol := server.Register("carol")
+
+	bob.Send("MODE bob +i")
+	bob.Expect(` MODE bob :?\+i$`)
+
+	// invisible to strangers, whatever mask they use
+	alice.Send("WHO bob")
+	alice.ExpectNone(` 352 `)
+	alice.Send("WHO *")
+	var nicks []string
+	for i := 0; i < 2; i++ {
+		nicks = append(nicks, alice.Expect(`^:irc.test.net 352 alice \* \S+ \S+ irc.test.net (\S+) `)[1])
+	}
+	assert.ElementsMatch([]string{"alice", "carol"}, nicks)
+	alice.Expect(`^:irc.test.net 315 alice \* `)
+	alice.Send("WHO")
+	alice.Expect(`^:irc.test.net 315 alice \* `)
+
+	// but not to themselves, to operators or to those in a channel with them
+	bob.Send("WHO bob")
+	bob.Expect(`^:irc.test.net 352 bob \* bob `)
+	carol.Oper()
+	carol.Send("WHO bob")
+	carol.Expect(`^:irc.test.net 352 carol \* bob `)
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+	alice.Send("WHO #test")
+	alice.ExpectNone(` 352 `)
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("WHO bob")
+	alice.Expect(`^:irc.test.net 352 alice \* bob `)
+
+	// only operators with "o"
+	alice.Send("WHO * o")
+	alice.Expect(`^:irc.test.net 352 alice \* carol \S+ irc.test.net carol H\* `)
+	alice.Expect(`^:irc.test.net 315 alice \* `)
+}
+
+func TestWhoChannelMask(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("JOIN #foo")
+	alice.Expect(`^:alice!\S+ JOIN #foo$`)
+	bob.Send("JOIN #bar")
+	bob.Expect(`^:bob!\S+ JOIN #bar$`)
+
+	alice.Send("WHO #b*")
+	alice.Expect(`^:irc.test.net 352 alice #bar bob `)
+	alice.Expect(`^:irc.test.net 315 alice #b\* `)
+
+	bob.Send("MODE #bar +s")
+	bob.Expect(` MODE #bar \+s$`)
+	alice.Send("WHO #b*")
+	alice.ExpectNone(` 352 `)
+}
+
+func TestWhox(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	server.Register("bob")
+
+	alice.Send("WHO bob %%tcuhnfar,42")
+	alice.Expect(`^:irc.test.net 354 alice 42 \* bob \S+ bob H 0 :bob$`)
+	alice.Expect(`^:irc.test.net 315 alice bob `)
+
+	// the fields come in a fixed order, and a bad token is dropped
+	alice.Send("WHO bob %%nti,abcd")
+	alice.Expect(`^:irc.test.net 354 alice 255.255.255.255 bob$`)
+	// test connections, like I2P and Tor ones, have no IP to show
+	alice.Send("WHO alice %%in")
+	alice.Expect(`^:irc.test.net 354 alice 255.255.255.255 alice$`)
+
+	alice.Send("WHO bob o%%n")
+	alice.ExpectNone(` 354 `)
+}
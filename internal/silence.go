@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// CallerIDNotifyInterval is how often a client in +g mode is told that
+// someone it hasn't accepted is messaging it.
+const CallerIDNotifyInterval = time.Minute
+
+// CanMessage reports whether c may send a private message to target, past
+// SILENCE, +g and +R. Only PRIVMSG is told why not: a NOTICE, which must
+// never trigger an automatic reply, or a TAGMSG is dropped quietly.
+func (c *Client) CanMessage(target *Client, code StringCode) bool {
+	explain := code == PRIVMSG
+
+	if target.silence.Match(c) {
+		return false
+	}
+	if c == target || c.modes.Has(Operator) || target.Accepts(c) {
+		return true
+	}
+	if target.modes.Has(RegOnlyMsg) && c.Account() == "" {
+		if explain {
+			c.ErrNoNonReg(target.Nick())
+		}
+		return false
+	}
+	if target.modes.Has(CallerID) {
+		if explain {
+			c.callerIDBlocked(target)
+		}
+		return false
+	}
+	return true
+}
+
+// Accepts reports whether other is on the client's ACCEPT list.
+func (c *Client) Accepts(other *Client) bool {
+	return c.accepted[other]
+}
+
+// accept puts other on the client's ACCEPT list.
+func (c *Client) accept(other *Client) {
+	c.accepted[other] = true
+	other.acceptedBy[c] = true
+}
+
+// unaccept takes other off the client's ACCEPT list.
+func (c *Client) unaccept(other *Client) {
+	delete(c.accepted, other)
+	delete(other.acceptedBy, c)
+}
+
+// dropAccepts takes the client off every ACCEPT list it is on, once it
+// changes nick or quits, so that whoever takes the nick next isn't let
+// through in its place.
+func (c *Client) dropAccepts() {
+	for other := range c.acceptedBy {
+		delete(other.accepted, c)
+	}
+	c.acceptedBy = make(map[*Client]bool)
+}
+
+// callerIDBlocked tells c its message didn't get through to target, and
+// target that c tried, at most once every CallerIDNotifyInterval.
+func (c *Client) callerIDBlocked(target *Client) {
+	c.RplTargUModeG(target.Nick())
+	now := time.Now()
+	if now.Sub(target.acceptNotice) < CallerIDNotifyInterval {
+		return
+	}
+	target.acceptNotice = now
+	target.RplUModeGMsg(c)
+	c.RplTargNotify(target.Nick())
+}
+
+// acceptReplies lets target answer a message c sent it while in +g mode.
+func (c *Client) acceptReplies(target *Client) {
+	if !c.modes.Has(CallerID) || c == target || c.Accepts(target) {
+		return
+	}
+	if len(c.accepted) < c.server.config.AcceptLimit() {
+		c.accept(target)
+	}
+}
+
+func (msg *SilenceCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	if len(msg.changes) == 0 {
+		client.silence.Range(func(mask *UserMask) bool {
+			client.RplSileList(mask)
+			return true
+		})
+		client.RplEndOfSileList()
+		return
+	}
+
+	for _, change := range msg.changes {
+		usermask, err := NewUserMask(change.mask)
+		if err != nil {
+			continue
+		}
+		switch change.op {
+		case Add:
+			if client.silence.Count() >= server.config.SilenceLimit() {
+				client.ErrSileListFull(usermask.mask)
+				continue
+			}
+			if client.silence.Add(usermask) {
+				client.Reply(RplSilence(client, Add, usermask.mask))
+			}
+		case Remove:
+			if client.silence.Remove(usermask.mask) {
+				client.Reply(RplSilence(client, Remove, usermask.mask))
+			}
+		}
+	}
+}
+
+func (msg *AcceptCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	for _, nick := range msg.nicks {
+		switch {
+		case nick == "*":
+			nicks := make([]string, 0, len(client.accepted))
+			for accepted := range client.accepted {
+				nicks = append(nicks, accepted.Nick().String())
+			}
+			sort.Strings(nicks)
+			if len(nicks) > 0 {
+				client.RplAcceptList(nicks)
+			}
+			client.RplEndOfAccept()
+
+		case strings.HasPrefix(nick.String(), "-"):
+			nick = nick[1:]
+			target := server.clients.Get(nick)
+			if target == nil || !client.Accepts(target) {
+				client.ErrAcceptNot(nick)
+				continue
+			}
+			client.unaccept(target)
+
+		default:
+			nick = Name(strings.TrimPrefix(nick.String(), "+"))
+			target := server.clients.Get(nick)
+			if target == nil {
+				client.ErrNoSuchNick(nick)
+				continue
+			}
+			if client.Accepts(target) {
+				client.ErrAcceptExist(target.Nick())
+				continue
+			}
+			if len(client.accepted) >= server.config.AcceptLimit() {
+				client.ErrAcceptFull()
+				continue
+			}
+			client.accept(target)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestSilence(t *testing.T) {
+	server := newTestServer(t, func(config *Config) {
+		config.Server.SilenceLimit = 2
+	})
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	alice.Send("SILENCE +bob")
+	alice.Expect(`^:alice!\S+ SILENCE \+bob!\*@\*$`)
+	alice.Send("SILENCE")
+	alice.Expect(`^:irc.test.net 271 alice alice bob!\*@\*$`)
+	alice.Expect(`^:irc.test.net 272 alice :End of Silence List$`)
+
+	// dropped without a word to either side
+	bob.Send("PRIVMSG alice :hi")
+	bob.Send("NOTICE alice :hi")
+	bob.ExpectNone(` 4\d\d `)
+	alice.ExpectNone(`^:bob!`)
+
+	alice.Send("SILENCE carol dave")
+	alice.Expect(`^:alice!\S+ SILENCE \+carol!\*@\*$`)
+	alice.Expect(`^:irc.test.net 511 alice dave!\*@\* `)
+
+	alice.Send("SILENCE -bob")
+	alice.Expect(`^:alice!\S+ SILENCE -bob!\*@\*$`)
+	bob.Send("PRIVMSG alice :hi")
+	alice.Expect(`^:bob!\S+ PRIVMSG alice :hi$`)
+}
+
+func TestCallerID(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	carol := server.Register("carol")
+
+	alice.Send("MODE alice +g")
+	alice.Expect(` MODE alice :?\+g$`)
+
+	bob.Send("PRIVMSG alice :hi")
+	bob.Expect(`^:irc.test.net 716 bob alice :is in \+g mode`)
+	bob.Expect(`^:irc.test.net 717 bob alice `)
+	alice.Expect(`^:irc.test.net 718 alice bob bob@\S+ :is messaging you`)
+
+	// alice is only told once a minute
+	carol.Send("PRIVMSG alice :hi")
+	carol.Expect(`^:irc.test.net 716 carol alice `)
+	carol.ExpectNone(` 717 `)
+	alice.ExpectNone(` 718 `)
+	carol.Send("NOTICE alice :hi")
+	carol.ExpectNone(` 716 `)
+
+	alice.Send("ACCEPT bob")
+	alice.Send("ACCEPT bob")
+	alice.Expect(`^:irc.test.net 457 alice bob `)
+	alice.Send("ACCEPT *")
+	alice.Expect(`^:irc.test.net 281 alice bob$`)
+	alice.Expect(`^:irc.test.net 282 alice `)
+	bob.Send("PRIVMSG alice :hi")
+	alice.Expect(`^:bob!\S+ PRIVMSG alice :hi$`)
+
+	// writing to someone lets them answer
+	alice.Send("PRIVMSG carol :hello")
+	carol.Expect(`^:alice!\S+ PRIVMSG carol :hello$`)
+	carol.Send("PRIVMSG alice :hi")
+	alice.Expect(`^:carol!\S+ PRIVMSG alice :hi$`)
+
+	alice.Send("ACCEPT -bob,-nobody")
+	alice.Expect(`^:irc.test.net 458 alice nobody `)
+	bob.Send("PRIVMSG alice :hi")
+	bob.Expect(`^:irc.test.net 716 bob alice `)
+}
+
+func TestAcceptNickChange(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	carol := server.Register("carol")
+
+	alice.Send("MODE alice +g")
+	alice.Expect(` MODE alice :?\+g$`)
+	alice.Send("ACCEPT bob")
+	bob.Send("PRIVMSG alice :hi")
+	alice.Expect(`^:bob!\S+ PRIVMSG alice :hi$`)
+
+	// the entry goes with bob's old nick, carol doesn't get it by taking it
+	bob.Send("NICK robert")
+	bob.Expect(`^:bob!\S+ NICK :?robert$`)
+	carol.Send("NICK bob")
+	carol.Expect(`^:carol!\S+ NICK :?bob$`)
+	carol.Send("PRIVMSG alice :hi")
+	carol.Expect(`^:irc.test.net 716 bob alice `)
+	bob.Send("PRIVMSG alice :hi")
+	bob.Expect(`^:irc.test.net 716 robert alice `)
+	alice.Send("ACCEPT *")
+	alice.Expect(`^:irc.test.net 282 alice `)
+}
+
+func TestRegOnlyMsg(t *testing.T) {
+	server := newTestServer(t, withOper(t), func(config *Config) {
+		config.Account = map[string]*AccountConfig{
+			"carol": {PassConfig: PassConfig{Password: testPassword(t, "secret")}},
+		}
+	})
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	carol := server.Connect()
+	carol.Send("CAP REQ :sasl")
+	carol.Send("AUTHENTICATE PLAIN")
+	carol.Send("AUTHENTICATE %s", saslPlain("carol", "secret"))
+	carol.Expect(` 903 `)
+	carol.Send("NICK carol")
+	carol.Send("USER carol 0 * :Carol")
+	carol.Send("CAP END")
+	carol.Expect(` 001 carol `)
+
+	alice.Send("MODE alice +R")
+	alice.Expect(` MODE alice :?\+R$`)
+	bob.Send("PRIVMSG alice :hi")
+	bob.Expect(`^:irc.test.net 486 bob alice `)
+	carol.Send("PRIVMSG alice :hi")
+	alice.Expect(`^:carol!\S+ PRIVMSG alice :hi$`)
+
+	// operators get through
+	bob.Oper()
+	bob.Send("PRIVMSG alice :hi")
+	alice.Expect(`^:bob!\S+ PRIVMSG alice :hi$`)
+}
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"io"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/prometheus/common/log"
 )
@@ -18,15 +20,29 @@ type Socket struct {
 	closed      bool
 	closedMutex sync.RWMutex
 	conn        net.Conn
-	scanner     *bufio.Scanner
+	reader      *bufio.Reader
 	writer      *bufio.Writer
+
+	// traffic, counted by the read and write goroutines
+	bytesRead    atomic.Uint64
+	linesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	linesWritten atomic.Uint64
+}
+
+// SocketStats is the traffic on a socket so far.
+type SocketStats struct {
+	BytesRead    uint64
+	LinesRead    uint64
+	BytesWritten uint64
+	LinesWritten uint64
 }
 
 func NewSocket(conn net.Conn) *Socket {
 	return &Socket{
-		conn:    conn,
-		scanner: bufio.NewScanner(conn),
-		writer:  bufio.NewWriter(conn),
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, MaxInputLen),
+		writer: bufio.NewWriter(conn),
 	}
 }
 
@@ -34,6 +50,17 @@ func (socket *Socket) String() string {
 	return socket.conn.RemoteAddr().String()
 }
 
+// Stats returns the traffic on the socket so far. It is safe to call from
+// any goroutine.
+func (socket *Socket) Stats() SocketStats {
+	return SocketStats{
+		BytesRead:    socket.bytesRead.Load(),
+		LinesRead:    socket.linesRead.Load(),
+		BytesWritten: socket.bytesWritten.Load(),
+		LinesWritten: socket.linesWritten.Load(),
+	}
+}
+
 func (socket *Socket) Close() {
 	socket.closedMutex.Lock()
 	defer socket.closedMutex.Unlock()
@@ -46,35 +73,61 @@ func (socket *Socket) Close() {
 	log.Debugf("%s closed", socket)
 }
 
-func (socket *Socket) Read() (line string, err error) {
+func (socket *Socket) isClosed() bool {
 	socket.closedMutex.RLock()
 	defer socket.closedMutex.RUnlock()
-	if socket.closed {
+
+	return socket.closed
+}
+
+// Read blocks for the next line. Only the client's read goroutine may call
+// it; Close unblocks it by closing the connection. A line longer than
+// MaxInputLen is skipped and reported as ErrInputTooLong.
+func (socket *Socket) Read() (line string, err error) {
+	if socket.isClosed() {
 		err = io.EOF
 		return
 	}
 
-	for socket.scanner.Scan() {
-		line = socket.scanner.Text()
-		if len(line) == 0 {
-			continue
+	for {
+		var data []byte
+		data, err = socket.reader.ReadSlice('\n')
+		socket.bytesRead.Add(uint64(len(data)))
+		if err == bufio.ErrBufferFull {
+			for err == bufio.ErrBufferFull {
+				var rest []byte
+				rest, err = socket.reader.ReadSlice('\n')
+				socket.bytesRead.Add(uint64(len(rest)))
+			}
+			if err == nil {
+				socket.linesRead.Add(1)
+				log.Debugf("%s → %s", socket, ErrInputTooLong)
+				return "", ErrInputTooLong
+			}
 		}
-		log.Debugf("%s → %s", socket, line)
-		return
-	}
 
-	err = socket.scanner.Err()
-	socket.isError(err, R)
-	if err == nil {
-		err = io.EOF
+		line = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		if err != nil {
+			socket.isError(err, R)
+			if err == io.EOF && len(line) > 0 {
+				// the last line, unterminated; EOF comes with the next Read
+				break
+			}
+			return "", err
+		}
+		if len(line) > 0 {
+			break
+		}
 	}
-	return
+
+	socket.linesRead.Add(1)
+	log.Debugf("%s → %s", socket, line)
+	return line, nil
 }
 
+// Write sends a line. Only the client's write goroutine may call it.
 func (socket *Socket) Write(line string) (err error) {
-	socket.closedMutex.RLock()
-	defer socket.closedMutex.RUnlock()
-	if socket.closed {
+	if socket.isClosed() {
 		err = io.EOF
 		return
 	}
@@ -90,6 +143,8 @@ func (socket *Socket) Write(line string) (err error) {
 	if err = socket.writer.Flush(); socket.isError(err, W) {
 		return
 	}
+	socket.bytesWritten.Add(uint64(len(line) + len(CRLF)))
+	socket.linesWritten.Add(1)
 
 	log.Debugf("%s ← %s", socket, line)
 	return
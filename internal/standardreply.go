@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"strings"
+)
+
+// StandardReply is an IRCv3 standard reply: a FAIL, WARN or NOTE about a
+// command, with a code for clients to act on and a description for users.
+type StandardReply struct {
+	Type        StringCode
+	Command     StringCode
+	Code        string
+	Description string
+}
+
+func NewFail(command StringCode, code string, description string) StandardReply {
+	return StandardReply{FAIL, command, code, description}
+}
+
+func NewWarn(command StringCode, code string, description string) StandardReply {
+	return StandardReply{WARN, command, code, description}
+}
+
+func NewNote(command StringCode, code string, description string) StandardReply {
+	return StandardReply{NOTE, command, code, description}
+}
+
+// The standard replies the server sends. Several may share a code, which
+// is what clients go by, and differ in their description.
+var (
+	FailBatchUnknownType = NewFail(BATCH, "UNKNOWN_TYPE", "Unsupported batch type")
+
+	FailSetNameInvalid = NewFail(SETNAME, "INVALID_REALNAME", "Realname is not valid")
+
+	FailVHostInvalid = NewFail(VHOST, "INVALID_VHOST", "Not a valid vhost")
+
+	FailFilterNoSuch = NewFail(FILTER, "NO_SUCH_FILTER", "No such filter")
+
+	FailMultilineNested      = NewFail(BATCH, "MULTILINE_INVALID", "Multiline batches can't be nested")
+	FailMultilineNoSuchBatch = NewFail(BATCH, "MULTILINE_INVALID", "No such batch")
+	FailMultilineEmpty       = NewFail(BATCH, "MULTILINE_INVALID", "Multiline batch is empty")
+	FailMultilineMixed       = NewFail(BATCH, "MULTILINE_INVALID", "Can't mix PRIVMSG and NOTICE in a batch")
+	FailMultilineBlankConcat = NewFail(BATCH, "MULTILINE_INVALID", "Concatenated lines can't be blank")
+	FailMultilineNoTarget    = NewFail(BATCH, "MULTILINE_INVALID_TARGET", "Multiline batch has no target")
+	FailMultilineTarget      = NewFail(BATCH, "MULTILINE_INVALID_TARGET", "Message target doesn't match the batch")
+	FailMultilineMaxBytes    = NewFail(BATCH, "MULTILINE_MAX_BYTES", "Multiline batch is too long")
+	FailMultilineMaxLines    = NewFail(BATCH, "MULTILINE_MAX_LINES", "Multiline batch has too many lines")
+)
+
+// Format returns the reply as sent from source, with context, the
+// parameters between the code and the description.
+func (reply StandardReply) Format(source Identifiable, context ...string) string {
+	params := append([]string{reply.Command.String(), reply.Code}, context...)
+	return NewStringReply(source, reply.Type, "%s :%s",
+		strings.Join(params, " "), reply.Description)
+}
+
+// capabilityCommands are the commands that only clients which enabled a
+// capability for them send. Those clients know FAIL replies to them.
+var capabilityCommands = map[StringCode]bool{
+	BATCH:   true,
+	SETNAME: true,
+}
+
+// StandardReply sends reply to the client. Clients that didn't enable
+// standard-replies get it as a NOTICE, except for a FAIL about a command
+// only clients using a capability send, which is always sent as is.
+func (target *Client) StandardReply(reply StandardReply, context ...string) {
+	if !target.capabilities[StandardReplies] &&
+		!(reply.Type == FAIL && capabilityCommands[reply.Command]) {
+		target.Reply(RplNotice(target.server, target, NewText(reply.Description)))
+		return
+	}
+	target.Reply(reply.Format(target.server, context...))
+}
@@ -0,0 +1,17 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandardReply(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t).Server
+	assert.Equal(":irc.test.net FAIL BATCH MULTILINE_MAX_LINES 100 :Multiline batch has too many lines",
+		FailMultilineMaxLines.Format(server, "100"))
+	assert.Equal(":irc.test.net NOTE * SOME_CODE :Something happened",
+		NewNote("*", "SOME_CODE", "Something happened").Format(server))
+}
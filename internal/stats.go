@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+func (msg *StatsCommand) HandleServer(server *Server) {
+	client := msg.Client()
+
+	switch msg.query {
+	case "u":
+		client.RplStatsUptime(time.Since(server.ctime))
+
+	case "m":
+		codes := make([]StringCode, 0, len(server.commandUsage))
+		for code := range server.commandUsage {
+			codes = append(codes, code)
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		for _, code := range codes {
+			client.RplStatsCommands(code, server.commandUsage[code])
+		}
+
+	case "l", "o", "k", "c":
+		if !client.modes.Has(Operator) || !client.canStats(msg.query) {
+			client.ErrNoPrivileges()
+			return
+		}
+		server.operatorStats(client, msg.query)
+	}
+
+	client.RplEndOfStats(msg.query)
+}
+
+// canStats reports whether the operator's class allows the STATS query.
+func (c *Client) canStats(query string) bool {
+	switch query {
+	case "l":
+		return c.HasPrivilege(PrivSeeHosts)
+	case "k":
+		return c.HasPrivilege(PrivBan)
+	}
+	return true
+}
+
+// operatorStats replies with the STATS queries only operators may make.
+func (server *Server) operatorStats(client *Client, query string) {
+	switch query {
+	case "l":
+		for conn := range server.conns {
+			client.RplStatsLinkInfo(conn)
+		}
+
+	case "o":
+		server.RLock()
+		names := make([]string, 0, len(server.operators))
+		for name := range server.operators {
+			names = append(names, name.String())
+		}
+		server.RUnlock()
+		sort.Strings(names)
+		for _, name := range names {
+			client.RplStatsOLine(Name(name))
+		}
+
+	case "k":
+		now := time.Now()
+		server.bans.Range(func(ban *UserMask) bool {
+			if !ban.Expired(now) {
+				client.RplStatsKLine(ban)
+			}
+			return true
+		})
+
+	case "c":
+		for _, listener := range server.listeners {
+			client.RplStatsCLine(listener.addr, listener.transport)
+		}
+	}
+}
+
+// linkName is how STATS l names a connection: nick[user@ip]. The address
+// comes from the socket, as c.ip may not be set yet.
+func (c *Client) linkName() string {
+	return fmt.Sprintf("%s[%s@%s]", c.Nick(), c.Username(), IPString(c.socket.conn.RemoteAddr()))
+}
+
+// traceClass is the connection class TRACE reports for the client.
+func (c *Client) traceClass() string {
+	if c.modes.Has(Operator) {
+		if c.operClass != nil && c.operClass.Name != "" {
+			return c.operClass.Name
+		}
+		return "opers"
+	}
+	return "users"
+}
+
+// TRACE shows operators every connection, or the one given; everyone else
+// only sees operators and themselves. Unregistered connections are only
+// shown, by IP, to operators that may see hosts.
+func (msg *TraceCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	isOperator := client.modes.Has(Operator)
+
+	var conns []*Client
+	if msg.target != "" {
+		target := server.clients.Get(msg.target)
+		if target == nil {
+			client.ErrNoSuchNick(msg.target)
+			return
+		}
+		conns = append(conns, target)
+	} else {
+		for conn := range server.conns {
+			conns = append(conns, conn)
+		}
+	}
+
+	for _, conn := range conns {
+		switch {
+		case !conn.registered:
+			if client.HasPrivilege(PrivSeeHosts) {
+				client.RplTraceUnknown(conn)
+			}
+		case conn.modes.Has(Operator):
+			client.RplTraceOperator(conn)
+		case isOperator || conn == client:
+			client.RplTraceUser(conn)
+		}
+	}
+	client.RplTraceEnd()
+}
+
+func (msg *CheckCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.HasPrivilege(PrivSeeHosts) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	if msg.target.IsChannel() {
+		channel := server.channels.Get(msg.target)
+		if channel == nil {
+			client.ErrNoSuchChannel(msg.target)
+			return
+		}
+		client.checkChannel(channel)
+		return
+	}
+
+	target := server.clients.Get(msg.target)
+	if target == nil {
+		client.ErrNoSuchNick(msg.target)
+		return
+	}
+	client.checkClient(target)
+}
+
+// checkReply sends one line of a CHECK report on target.
+func (c *Client) checkReply(target Name, format string, args ...interface{}) {
+	text := fmt.Sprintf("[CHECK %s] ", target) + fmt.Sprintf(format, args...)
+	c.Reply(RplNotice(c.server, c, NewText(text)))
+}
+
+// checkClient reports everything there is to know about target.
+func (c *Client) checkClient(target *Client) {
+	nick := target.Nick()
+	c.checkReply(nick, "%s (%s)", target.UserHost(false), target.realname)
+	c.checkReply(nick, "host: %s [%s], shown as %s", target.hostname, target.ip, target.hostmask)
+	c.checkReply(nick, "modes: %s", target.ModeString())
+	c.checkReply(nick, "channels: %s", strings.Join(target.WhoisChannelsNames(c), " "))
+	if account := target.Account(); account != "" {
+		c.checkReply(nick, "account: %s", account)
+	} else {
+		c.checkReply(nick, "account: none")
+	}
+	c.checkReply(nick, "capabilities: %s", target.capabilities)
+	c.checkReply(nick, "connected: %s, idle %ds",
+		target.ctime.UTC().Format(time.RFC1123), target.IdleSeconds())
+
+	transport := string(target.transport)
+	if conn, ok := target.socket.conn.(*tls.Conn); ok {
+		state := conn.ConnectionState()
+		transport += fmt.Sprintf(" (%s, %s)",
+			tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	}
+	c.checkReply(nick, "transport: %s", transport)
+	if target.certfp != "" {
+		c.checkReply(nick, "certfp: %s", target.certfp)
+	}
+
+	stats := target.socket.Stats()
+	c.checkReply(nick, "sendq: %d of %d bytes", target.sendQ.Len(), target.sendQ.limit)
+	c.checkReply(nick, "sent: %d lines, %d bytes; received: %d lines, %d bytes",
+		stats.LinesWritten, stats.BytesWritten, stats.LinesRead, stats.BytesRead)
+	c.checkReply(nick, "End of CHECK")
+}
+
+// checkChannel reports the state of channel and its members.
+func (c *Client) checkChannel(channel *Channel) {
+	name := channel.name
+	c.checkReply(name, "modes: %s", channel.ModeString(c))
+	c.checkReply(name, "topic: %s", channel.topic)
+	c.checkReply(name, "members: %d", channel.members.Count())
+	channel.members.Range(func(member *Client, modes *ChannelModeSet) bool {
+		prefix := ""
+		if modes.Has(ChannelOperator) {
+			prefix += "@"
+		}
+		if modes.Has(Voice) {
+			prefix += "+"
+		}
+		c.checkReply(name, "%s%s", prefix, member.UserHost(false))
+		return true
+	})
+	c.checkReply(name, "End of CHECK")
+}
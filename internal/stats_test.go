@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newTestServer(t, withOper(t))
+	alice := server.Register("alice")
+
+	alice.Send("STATS u")
+	alice.Expect(`^:irc.test.net 242 alice :Server Up 0 days 0:00:\d\d$`)
+	alice.Expect(`^:irc.test.net 219 alice u :End of STATS report$`)
+
+	alice.Send("STATS m")
+	alice.Expect(`^:irc.test.net 212 alice STATS 2$`)
+	alice.Expect(`^:irc.test.net 219 alice m `)
+
+	alice.Send("STATS l")
+	alice.Expect(`^:irc.test.net 481 alice `)
+
+	alice.Oper()
+	alice.Send("STATS o")
+	alice.Expect(`^:irc.test.net 243 alice O \* \* oper$`)
+	alice.Expect(`^:irc.test.net 219 alice o `)
+
+	alice.Send("STATS l")
+	match := alice.Expect(`^:irc.test.net 211 alice alice\[alice@\S+\] \d+ (\d+) \d+ (\d+) \d+ :\d+$`)
+	assert.NotEqual("0", match[1])
+	assert.NotEqual("0", match[2])
+	alice.Expect(`^:irc.test.net 219 alice l `)
+}
+
+func TestTrace(t *testing.T) {
+	server := newTestServer(t, withOper(t))
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+	server.Connect()
+
+	bob.Send("TRACE")
+	bob.Expect(`^:irc.test.net 205 bob User users bob$`)
+	bob.Expect(`^:irc.test.net 262 bob irc.test.net \S+ :End of TRACE$`)
+	bob.Send("TRACE alice")
+	bob.ExpectNone(` 205 `)
+
+	alice.Oper()
+	bob.Send("TRACE alice")
+	bob.Expect(`^:irc.test.net 204 bob Oper opers alice$`)
+	alice.Send("TRACE")
+	alice.Expect(`^:irc.test.net 203 alice \?\?\?\? users \S+$`)
+	alice.Send("TRACE nobody")
+	alice.Expect(`^:irc.test.net 401 alice nobody `)
+}
+
+func TestCheck(t *testing.T) {
+	server := newTestServer(t, withOper(t))
+	alice := server.Register("alice")
+	bob := server.Register("bob")
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+
+	alice.Send("CHECK bob")
+	alice.Expect(`^:irc.test.net 481 alice `)
+
+	alice.Oper()
+	alice.Send("CHECK bob")
+	alice.Expect(`^:irc.test.net NOTICE alice :\[CHECK bob\] bob!bob@\S+ \(bob\)$`)
+	alice.Expect(`:\[CHECK bob\] channels: @#test$`)
+	alice.Expect(`:\[CHECK bob\] account: none$`)
+	alice.Expect(`:\[CHECK bob\] transport: TCP$`)
+	alice.Expect(`:\[CHECK bob\] sendq: \d+ of \d+ bytes$`)
+	alice.Expect(`:\[CHECK bob\] sent: [1-9]\d* lines, \d+ bytes; received: [1-9]\d* lines, \d+ bytes$`)
+	alice.Expect(`:\[CHECK bob\] End of CHECK$`)
+
+	alice.Send("CHECK #test")
+	alice.Expect(`:\[CHECK #test\] members: 1$`)
+	alice.Expect(`:\[CHECK #test\] @bob!bob@\S+$`)
+	alice.Expect(`:\[CHECK #test\] End of CHECK$`)
+}
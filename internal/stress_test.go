@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConcurrentClients hammers one server with clients joining, parting,
+// renaming and messaging each other at the same time. It is most useful
+// under the race detector: go test -race -run TestConcurrentClients
+func TestConcurrentClients(t *testing.T) {
+	const (
+		nClients   = 20
+		nCommands  = 100
+		nChannels  = 3
+		waitForAll = 30 * time.Second
+	)
+
+	server := newTestServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < nClients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			conn, remote := net.Pipe()
+			server.accept(remote, TransportTCP)
+
+			// drain everything the server sends until it hangs up
+			drained := make(chan bool)
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+				}
+				close(drained)
+			}()
+
+			send := func(format string, args ...interface{}) {
+				fmt.Fprintf(conn, format+"\r\n", args...)
+			}
+
+			rnd := rand.New(rand.NewSource(int64(i)))
+			send("NICK user%d", i)
+			send("USER user%d 0 * :Stress Test", i)
+			for n := 0; n < nCommands; n++ {
+				channel := fmt.Sprintf("#stress%d", rnd.Intn(nChannels))
+				switch rnd.Intn(6) {
+				case 0:
+					send("JOIN %s", channel)
+				case 1:
+					send("PART %s", channel)
+				case 2:
+					send("NICK user%d_%d", i, n)
+				case 3:
+					send("PRIVMSG %s :hello %d", channel, n)
+				case 4:
+					send("PRIVMSG user%d :hi", rnd.Intn(nClients))
+				case 5:
+					send("TOPIC %s :topic %d", channel, n)
+				}
+			}
+			send("QUIT :done")
+			<-drained
+		}(i)
+	}
+
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(waitForAll):
+		t.Fatal("timed out waiting for clients to finish")
+	}
+
+	// every client has quit, so every channel should be gone
+	assert.Equal(t, 0, server.clients.Count())
+	assert.Equal(t, 0, server.channels.Count())
+}
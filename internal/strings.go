@@ -35,6 +35,11 @@ func (name Name) IsChannel() bool {
 	return ChannelNameExpr.MatchString(name.String())
 }
 
+// IsChannelMask reports whether name is a channel name or a mask of them.
+func (name Name) IsChannelMask() bool {
+	return name != "" && strings.Contains("&!#+", string(name[0]))
+}
+
 func (name Name) IsNickname() bool {
 	namestr := name.String()
 	// * is used for unregistered clients
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServerTimeFormat is the format of the server-time `time` tag.
+const ServerTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Tags are IRCv3 message tags. A recipient only gets the tags it enabled
+// the matching capability for; see TagCapabilities.
+type Tags map[string]string
+
+// ClientTagPrefix marks client-only tags, which the server relays as is.
+const ClientTagPrefix = "+"
+
+// TagCapabilities maps a tag to the capability a client needs to be sent it.
+var TagCapabilities = map[string]Capability{
+	"time":    ServerTime,
+	"account": AccountTag,
+	"batch":   Batch,
+	"label":   LabeledResponse,
+	"msgid":   MessageTags,
+}
+
+// tagCapability returns the capability a client needs to be sent key.
+func tagCapability(key string) (Capability, bool) {
+	if strings.HasPrefix(key, ClientTagPrefix) {
+		return MessageTags, true
+	}
+	capability, ok := TagCapabilities[key]
+	return capability, ok
+}
+
+// For returns the tag prefix, including the leading '@' and trailing space,
+// to send tags to client. It is empty if the client gets none of them.
+// Clients with server-time get a time tag even if tags has none.
+func (tags Tags) For(client *Client) string {
+	keys := make([]string, 0, len(tags)+1)
+	for key := range tags {
+		if capability, ok := tagCapability(key); ok && !client.capabilities[capability] {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	_, hasTime := tags["time"]
+	if !hasTime && client.capabilities[ServerTime] {
+		keys = append(keys, "time")
+	}
+
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteByte('@')
+	for index, key := range keys {
+		if index > 0 {
+			builder.WriteByte(';')
+		}
+		builder.WriteString(key)
+
+		value, ok := tags[key]
+		if !ok && key == "time" {
+			value = tagTime()
+		}
+		if value != "" {
+			builder.WriteByte('=')
+			builder.WriteString(EscapeTagValue(value))
+		}
+	}
+	builder.WriteByte(' ')
+
+	return builder.String()
+}
+
+var tagValueEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\:",
+	" ", "\\s",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+// EscapeTagValue escapes a tag value for the wire.
+func EscapeTagValue(value string) string {
+	return tagValueEscaper.Replace(value)
+}
+
+// UnescapeTagValue undoes EscapeTagValue. A backslash before any other
+// character is dropped, as is a trailing one.
+func UnescapeTagValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			builder.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			builder.WriteByte(';')
+		case 's':
+			builder.WriteByte(' ')
+		case 'r':
+			builder.WriteByte('\r')
+		case 'n':
+			builder.WriteByte('\n')
+		default:
+			builder.WriteByte(value[i])
+		}
+	}
+	return builder.String()
+}
+
+// ParseTags parses the tags of a message, without the leading '@'. Later
+// duplicates win.
+func ParseTags(raw string) Tags {
+	tags := make(Tags)
+	for _, tag := range strings.Split(raw, ";") {
+		key, value, _ := strings.Cut(tag, "=")
+		if key == "" {
+			continue
+		}
+		tags[key] = UnescapeTagValue(value)
+	}
+	return tags
+}
+
+// with returns a copy of tags with key set to value.
+func (tags Tags) with(key, value string) Tags {
+	copied := make(Tags, len(tags)+1)
+	for k, v := range tags {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+// tagTime returns the current time formatted for the `time` tag.
+func tagTime() string {
+	return time.Now().UTC().Format(ServerTimeFormat)
+}
+
+var msgIDEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewMsgID returns a random message ID.
+func NewMsgID() string {
+	id := make([]byte, 15)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return strings.ToLower(msgIDEncoding.EncodeToString(id))
+}
+
+// Tags returns the tags for a message sent by the client: when it was sent
+// and, if the client is logged in, its account.
+func (c *Client) Tags() Tags {
+	tags := Tags{"time": tagTime()}
+	if account := c.Account(); account != "" {
+		tags["account"] = account
+	}
+	return tags
+}
+
+// MessageTags returns the tags for a PRIVMSG, NOTICE or TAGMSG sent by the
+// client: those of Tags, a new msgid, and the client-only tags in sent.
+func (c *Client) MessageTags(sent Tags) Tags {
+	tags := c.Tags()
+	tags["msgid"] = NewMsgID()
+	for key, value := range sent {
+		if strings.HasPrefix(key, ClientTagPrefix) {
+			tags[key] = value
+		}
+	}
+	return tags
+}
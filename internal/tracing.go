@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	DefaultTracingExporter = "otlp"
+
+	// how long Shutdown waits for buffered spans to be exported
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// Span attribute keys.
+const (
+	attrCommand    = attribute.Key("irc.command")
+	attrNick       = attribute.Key("irc.nick")
+	attrChannel    = attribute.Key("irc.channel")
+	attrRecipients = attribute.Key("irc.recipients")
+	attrRemoteAddr = attribute.Key("net.peer.addr")
+)
+
+// Tracing hands out the server's OpenTelemetry spans. When tracing is
+// disabled every span is a no-op, so callers never need to check.
+type Tracing struct {
+	provider trace.TracerProvider
+	tracer   trace.Tracer
+	shutdown func(context.Context) error
+}
+
+func NewTracing(config TracingConfig, name Name) (*Tracing, error) {
+	if !config.Enabled {
+		return newTracing(noop.NewTracerProvider(), nil), nil
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch config.Exporter {
+	case "", "otlp":
+		opts := []otlptracegrpc.Option{}
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), opts...)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(
+			sdktrace.TraceIDRatioBased(config.SampleRatio()),
+		)),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", Package),
+			attribute.String("service.version", Version),
+			attribute.String("service.instance.id", name.String()),
+		)),
+	)
+
+	return newTracing(provider, provider.Shutdown), nil
+}
+
+func newTracing(provider trace.TracerProvider, shutdown func(context.Context) error) *Tracing {
+	return &Tracing{
+		provider: provider,
+		tracer:   provider.Tracer(Package),
+		shutdown: shutdown,
+	}
+}
+
+// Start starts a span as a child of any span in ctx.
+func (t *Tracing) Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartLinked starts a new trace that links back to the span in ctx. It is
+// used for commands, so that a long-lived connection does not turn into a
+// single trace with thousands of spans.
+func (t *Tracing) StartLinked(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(context.Background(), name,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// Shutdown flushes any buffered spans.
+func (t *Tracing) Shutdown() {
+	if t.shutdown == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := t.shutdown(ctx); err != nil {
+		log.Errorf("tracing shutdown error: %s", err)
+	}
+}
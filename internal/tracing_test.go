@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTracedTestServer(t *testing.T, configure ...func(*Config)) (*testServer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	server := newTestServer(t, configure...)
+	// no client has connected yet, so nothing has looked at the tracer
+	server.tracing = newTracing(provider, provider.Shutdown)
+
+	return server, exporter
+}
+
+func findSpans(spans tracetest.SpanStubs, name string) tracetest.SpanStubs {
+	var found tracetest.SpanStubs
+	for _, span := range spans {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	return found
+}
+
+func TestTracing(t *testing.T) {
+	assert := assert.New(t)
+
+	server, exporter := newTracedTestServer(t, func(config *Config) {
+		config.Server.Password = testPassword(t, "secret")
+	})
+
+	alice := server.Connect()
+	alice.Send("PASS secret")
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Expect(`^:irc.test.net 001 alice `)
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	alice.Send("PRIVMSG #test :hello")
+	alice.Send("QUIT :bye")
+	alice.ExpectClosed()
+
+	spans := exporter.GetSpans()
+
+	connection := findSpans(spans, "connection")
+	require.Len(t, connection, 1)
+	conn := connection[0].SpanContext
+
+	// connection lifecycle
+	for _, name := range []string{"dns", "password", "register"} {
+		found := findSpans(spans, name)
+		if assert.Len(found, 1, name) {
+			assert.Equal(conn.TraceID(), found[0].Parent.TraceID(), name)
+			assert.Equal(conn.SpanID(), found[0].Parent.SpanID(), name)
+		}
+	}
+	assert.Equal(codes.Unset, findSpans(spans, "register")[0].Status.Code)
+
+	// commands are their own traces, linked to the connection
+	privmsg := findSpans(spans, "command PRIVMSG")
+	require.Len(t, privmsg, 1)
+	assert.NotEqual(conn.TraceID(), privmsg[0].SpanContext.TraceID())
+	require.Len(t, privmsg[0].Links, 1)
+	assert.Equal(conn.SpanID(), privmsg[0].Links[0].SpanContext.SpanID())
+	assert.Contains(privmsg[0].Attributes, attrCommand.String("PRIVMSG"))
+	assert.Contains(privmsg[0].Attributes, attrNick.String("alice"))
+
+	// fan-outs hang off the command that caused them
+	fanout := findSpans(spans, "fanout PRIVMSG")
+	require.Len(t, fanout, 1)
+	assert.Equal(privmsg[0].SpanContext.SpanID(), fanout[0].Parent.SpanID())
+	assert.Contains(fanout[0].Attributes, attrChannel.String("#test"))
+	assert.Contains(fanout[0].Attributes, attrRecipients.Int(1))
+
+	assert.Len(findSpans(spans, "command JOIN"), 1)
+	assert.Len(findSpans(spans, "fanout JOIN"), 1)
+}
+
+func TestTracingUnregistered(t *testing.T) {
+	server, exporter := newTracedTestServer(t)
+
+	client := server.Connect()
+	client.Send("QUIT")
+	client.ExpectClosed()
+
+	register := findSpans(exporter.GetSpans(), "register")
+	require.Len(t, register, 1)
+	assert.Equal(t, codes.Error, register[0].Status.Code)
+}
+
+func TestTracingConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	config := TracingConfig{}
+	assert.Equal(1.0, config.SampleRatio())
+	ratio := 0.0
+	config.Sample = &ratio
+	assert.Equal(0.0, config.SampleRatio())
+
+	tracing, err := NewTracing(TracingConfig{}, NewName("irc.test.net"))
+	assert.NoError(err)
+	_, span := tracing.Start(context.Background(), "noop")
+	assert.False(span.IsRecording())
+
+	_, err = NewTracing(TracingConfig{Enabled: true, Exporter: "carrier-pigeon"}, NewName("irc.test.net"))
+	assert.Error(err)
+
+	tracing, err = NewTracing(TracingConfig{Enabled: true, Exporter: "stdout"}, NewName("irc.test.net"))
+	assert.NoError(err)
+	tracing.Shutdown()
+}
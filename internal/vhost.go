@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxVHostLen = 64
+	vhostChars  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789.-/:_"
+)
+
+// ValidVHost reports whether vhost can be shown as a host: it may have
+// slashes, as in contributor/alice, but nothing that would break a
+// nick!user@host mask or a line.
+func ValidVHost(vhost Name) bool {
+	str := vhost.String()
+	if str == "" || len(str) > MaxVHostLen || strings.HasPrefix(str, ":") {
+		return false
+	}
+	for _, char := range str {
+		if !strings.ContainsRune(vhostChars, char) {
+			return false
+		}
+	}
+	return true
+}
+
+// AccountVHost returns the vhost of account: one set with VHOST, which
+// lasts until the server restarts, or else the one in the config.
+func (server *Server) AccountVHost(account string) Name {
+	if vhost, ok := server.operVHosts[account]; ok {
+		return vhost
+	}
+	return server.vhosts[account]
+}
+
+func (msg *VHostCommand) HandleServer(server *Server) {
+	client := msg.Client()
+	if !client.HasPrivilege(PrivVHost) {
+		client.ErrNoPrivileges()
+		return
+	}
+
+	if msg.vhost != "" && !ValidVHost(msg.vhost) {
+		client.StandardReply(FailVHostInvalid, msg.vhost.String())
+		return
+	}
+
+	switch msg.subCommand {
+	case "SET", "CLEAR":
+		target := server.clients.Get(msg.target)
+		if target == nil {
+			client.ErrNoSuchNick(msg.target)
+			return
+		}
+		target.changeVHost(msg.vhost)
+		client.Reply(RplNotice(server, client, NewText(fmt.Sprintf(
+			"vhost of %s is now %s", target.Nick(), target.hostmask))))
+
+	case "SETACCOUNT", "CLEARACCOUNT":
+		account := msg.target.String()
+		server.operVHosts[account] = msg.vhost
+		vhost := server.AccountVHost(account)
+		server.clients.Range(func(_ Name, other *Client) bool {
+			if other.Account() == account {
+				other.changeVHost(vhost)
+			}
+			return true
+		})
+		if vhost == "" {
+			vhost = "cleared"
+		}
+		client.Reply(RplNotice(server, client, NewText(fmt.Sprintf(
+			"vhost of account %s is now %s", account, vhost))))
+	}
+}
+
+// changeVHost sets or clears the client's vhost on an operator's behalf,
+// and tells the client.
+func (c *Client) changeVHost(vhost Name) {
+	c.SetVHost(vhost)
+	c.Reply(RplNotice(c.server, c, NewText(fmt.Sprintf(
+		"Your displayed host is now %s", c.hostmask))))
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetName(t *testing.T) {
+	server := newTestServer(t)
+	alice := server.RegisterWithCaps("alice", "setname")
+	bob := server.RegisterWithCaps("bob", "setname")
+	carol := server.Register("carol")
+
+	alice.Send("JOIN #test")
+	alice.Expect(`^:alice!\S+ JOIN #test$`)
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+	carol.Send("JOIN #test")
+	carol.Expect(`^:carol!\S+ JOIN #test$`)
+
+	alice.Send("SETNAME :Alice Liddell")
+	alice.Expect(`^:alice!\S+ SETNAME :Alice Liddell$`)
+	bob.Expect(`^:alice!\S+ SETNAME :Alice Liddell$`)
+	carol.ExpectNone(` SETNAME `)
+
+	carol.Send("WHOIS alice")
+	carol.Expect(`^:irc.test.net 311 carol alice alice \S+ \* :Alice Liddell$`)
+
+	alice.Send("SETNAME :")
+	alice.Expect(`^:irc.test.net FAIL SETNAME INVALID_REALNAME :Realname is not valid$`)
+}
+
+func TestVHost(t *testing.T) {
+	server := newTestServer(t, withOper(t))
+	alice := server.Register("alice")
+	bob := server.RegisterWithCaps("bob", "chghost")
+	carol := server.Register("carol")
+	alice.Oper()
+
+	bob.Send("JOIN #test")
+	bob.Expect(`^:bob!\S+ JOIN #test$`)
+	carol.Send("JOIN #test")
+	bob.Expect(`^:carol!\S+ JOIN #test$`)
+
+	carol.Send("VHOST SET carol x")
+	carol.Expect(`^:irc.test.net 481 carol `)
+
+	alice.Send("VHOST SET carol contributor/carol")
+	alice.Expect(`^:irc.test.net NOTICE alice :vhost of carol is now contributor/carol$`)
+	carol.Expect(`^:irc.test.net NOTICE carol :Your displayed host is now contributor/carol$`)
+	match := bob.Expect(`^:carol!carol@(\S+) CHGHOST carol contributor/carol$`)
+	cloak := match[1]
+
+	bob.Send("WHOIS carol")
+	bob.Expect(`^:irc.test.net 311 bob carol carol contributor/carol `)
+
+	alice.Send("VHOST CLEAR carol")
+	bob.Expect(`^:carol!carol@contributor/carol CHGHOST carol %s$`, cloak)
+
+	alice.Send("VHOST SET carol bad@host")
+	alice.Expect(`^:irc.test.net NOTICE alice :Not a valid vhost$`)
+	alice.Send("CAP REQ :standard-replies")
+	alice.Expect(` ACK :standard-replies$`)
+	alice.Send("VHOST SET carol bad@host")
+	alice.Expect(`^:irc.test.net FAIL VHOST INVALID_VHOST bad@host :Not a valid vhost$`)
+}
+
+func TestAccountVHost(t *testing.T) {
+	server := newTestServer(t, withOper(t), func(config *Config) {
+		config.Account = map[string]*AccountConfig{
+			"alice": {PassConfig: PassConfig{Password: testPassword(t, "secret")}},
+		}
+	})
+	oper := server.Register("oper")
+	oper.Oper()
+
+	oper.Send("VHOST SETACCOUNT alice staff/alice")
+	oper.Expect(`^:irc.test.net NOTICE oper :vhost of account alice is now staff/alice$`)
+
+	alice := server.Connect()
+	alice.Send("CAP LS")
+	alice.Send("CAP REQ :sasl")
+	alice.Send("AUTHENTICATE PLAIN")
+	alice.Send("AUTHENTICATE %s", saslPlain("alice", "secret"))
+	alice.Expect(` 903 `)
+	alice.Send("NICK alice")
+	alice.Send("USER alice 0 * :Alice")
+	alice.Send("CAP END")
+	alice.Expect(` 001 alice :Welcome to the TestNet Internet Relay Network alice!alice@staff/alice$`)
+
+	oper.Send("VHOST CLEARACCOUNT alice")
+	oper.Expect(`^:irc.test.net NOTICE oper :vhost of account alice is now cleared$`)
+	alice.Expect(`^:irc.test.net NOTICE alice :Your displayed host is now \S+$`)
+}
+
+func TestValidVHost(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(ValidVHost("contributor/alice"))
+	assert.True(ValidVHost("alice.users.example"))
+	assert.False(ValidVHost(""))
+	assert.False(ValidVHost(":alice"))
+	assert.False(ValidVHost("a b"))
+	assert.False(ValidVHost("alice!x"))
+}
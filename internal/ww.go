@@ -72,6 +72,10 @@ func (server *Server) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
 			lang = cleaned
 		}
 	}
+	// REHASH merges into the config on the server goroutine
+	server.config.Lock()
+	defer server.config.Unlock()
+
 	log.Infof("Rendering language: %d %s, %s", len(tmp), lang, server.templates[lang])
 	tmpl, err := template.New(server.config.Network.Name).Parse(server.templates[lang])
 	if err != nil {